@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type argKind int
+
+const (
+	argString argKind = iota
+	// A source file, "-" stands for the irony temp file.
+	argFile
+	argPath
+	argInt
+	argBool
+	argEnum
+)
+
+var argKindNames = map[argKind]string{
+	argString: "string",
+	argFile:   "file",
+	argPath:   "path",
+	argInt:    "integer",
+	argBool:   "boolean",
+	argEnum:   "enum",
+}
+
+// ArgDef describes one positional argument of a command. Optional
+// arguments must come after the required ones.
+type ArgDef struct {
+	Name     string
+	Kind     argKind
+	Optional bool
+	Values   []string
+	// Lenient arguments accept any value, as the commands did before
+	// arguments were checked: an unknown enum value is left to the
+	// command and a boolean is on if it is on in any case, else off.
+	Lenient bool
+}
+
+func stringArg(name string) ArgDef {
+	return ArgDef{name, argString, false, nil, false}
+}
+
+func fileArg(name string) ArgDef {
+	return ArgDef{name, argFile, false, nil, false}
+}
+
+func pathArg(name string) ArgDef {
+	return ArgDef{name, argPath, false, nil, false}
+}
+
+func intArg(name string) ArgDef {
+	return ArgDef{name, argInt, false, nil, false}
+}
+
+func boolArg(name string) ArgDef {
+	return ArgDef{name, argBool, false, nil, false}
+}
+
+func enumArg(name string, values ...string) ArgDef {
+	return ArgDef{name, argEnum, false, values, false}
+}
+
+func optional(arg ArgDef) ArgDef {
+	arg.Optional = true
+	return arg
+}
+
+func lenient(arg ArgDef) ArgDef {
+	arg.Lenient = true
+	return arg
+}
+
+func (arg *ArgDef) metaName() string {
+	return strings.ToUpper(arg.Name)
+}
+
+func (arg *ArgDef) parse(value string) (interface{}, error) {
+	switch arg.Kind {
+	case argFile:
+		return fixupFileName(value), nil
+	case argInt:
+		v, err := parseUint(value)
+		if err != nil {
+			return nil, errInteger(arg.Name, value)
+		}
+		return v, nil
+	case argBool:
+		if arg.Lenient {
+			return strings.ToLower(value) == "on", nil
+		}
+		return parseBool(value)
+	case argEnum:
+		if !arg.Lenient && !stringInSlice(value, arg.Values) {
+			return nil, errArgument(fmt.Sprintf("%s must be one of %s",
+				arg.Name, strings.Join(arg.Values, ", ")), value)
+		}
+	}
+	return value, nil
+}
+
+// commandArgs holds the validated arguments of a command.
+type commandArgs struct {
+	name   string
+	values map[string]interface{}
+	// Compile options given after "--".
+	flags []string
+	// Raw content following the command line.
+	payload string
+}
+
+func (a *commandArgs) has(name string) bool {
+	_, ok := a.values[name]
+	return ok
+}
+
+func (a *commandArgs) str(name string) string {
+	v, _ := a.values[name].(string)
+	return v
+}
+
+func (a *commandArgs) uint(name string) uint32 {
+	v, _ := a.values[name].(uint32)
+	return v
+}
+
+func (a *commandArgs) bool(name string) bool {
+	v, _ := a.values[name].(bool)
+	return v
+}
+
+func (cmd *CommandDef) arg(name string) *ArgDef {
+	for i := range cmd.Args {
+		if cmd.Args[i].Name == name {
+			return &cmd.Args[i]
+		}
+	}
+	return nil
+}
+
+func (cmd *CommandDef) option(name string) *ArgDef {
+	for i := range cmd.Options {
+		if cmd.Options[i].Name == name {
+			return &cmd.Options[i]
+		}
+	}
+	return nil
+}
+
+// optionUsage returns the option as written in the usage of a command, e.g.
+// "[--doc]" or "[--limit=N]".
+func (opt *ArgDef) optionUsage() string {
+	if opt.Kind == argBool {
+		return "[--" + opt.Name + "]"
+	}
+	return "[--" + opt.Name + "=" + opt.metaName() + "]"
+}
+
+func (cmd *CommandDef) usage() string {
+	var s, closing string
+	for _, arg := range cmd.Args {
+		if s != "" {
+			s += " "
+		}
+		if arg.Optional {
+			s += "["
+			closing += "]"
+		}
+		s += arg.metaName()
+	}
+	s += closing
+	for _, opt := range cmd.Options {
+		if s != "" {
+			s += " "
+		}
+		s += opt.optionUsage()
+	}
+	if cmd.CompileOptions {
+		if s != "" {
+			s += " "
+		}
+		s += "[-- [COMPILE_OPTIONS...]]"
+	}
+	if cmd.Payload {
+		s += " <NBYTES bytes of content>"
+	}
+	return s
+}
+
+func (cmd *CommandDef) help() string {
+	s := cmd.Desc
+	if usage := cmd.usage(); usage != "" {
+		s = usage + " - " + s
+	}
+	for _, arg := range cmd.Args {
+		switch arg.Kind {
+		case argEnum:
+			s += fmt.Sprintf(", %s is one of %s", arg.metaName(), strings.Join(arg.Values, ", "))
+		case argBool:
+			s += fmt.Sprintf(", %s is on or off", arg.metaName())
+		}
+	}
+	for _, opt := range cmd.Options {
+		if opt.Kind == argEnum {
+			s += fmt.Sprintf(", %s is one of %s", opt.metaName(), strings.Join(opt.Values, ", "))
+		}
+	}
+	return s
+}
+
+// parseOption validates word, "--NAME[=VALUE]", as an option of cmd.
+func (cmd *CommandDef) parseOption(word string, args *commandArgs) error {
+	name := strings.TrimPrefix(word, "--")
+	value, hasValue := "", false
+	if i := strings.IndexByte(name, '='); i >= 0 {
+		name, value, hasValue = name[:i], name[i+1:], true
+	}
+	opt := cmd.option(name)
+	if opt == nil {
+		return errArgument("unknown option", word)
+	}
+	if !hasValue {
+		if opt.Kind != argBool {
+			return errArgument("missing option value", word)
+		}
+		value = "on"
+	}
+	v, err := opt.parse(value)
+	if err != nil {
+		return err
+	}
+	args.values[opt.Name] = v
+	return nil
+}
+
+// parseArgs validates words, the command name followed by its arguments,
+// against the argument schema of cmd.
+func (cmd *CommandDef) parseArgs(words []string) (*commandArgs, error) {
+	args := &commandArgs{name: cmd.Name, values: make(map[string]interface{})}
+	words = words[1:]
+	if cmd.Payload {
+		if len(words) == 0 {
+			return nil, errArgCount(cmd)
+		}
+		args.payload = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+	if cmd.CompileOptions {
+		for i, word := range words {
+			if word == "--" {
+				args.flags = words[i+1:]
+				words = words[:i]
+				break
+			}
+		}
+	}
+	if len(cmd.Options) > 0 {
+		var positional []string
+		for _, word := range words {
+			if len(word) <= 2 || !strings.HasPrefix(word, "--") {
+				positional = append(positional, word)
+				continue
+			}
+			if err := cmd.parseOption(word, args); err != nil {
+				return nil, err
+			}
+		}
+		words = positional
+	}
+	required := 0
+	for _, arg := range cmd.Args {
+		if !arg.Optional {
+			required += 1
+		}
+	}
+	if len(words) < required || len(words) > len(cmd.Args) {
+		return nil, errArgCount(cmd)
+	}
+	for i, word := range words {
+		arg := &cmd.Args[i]
+		v, err := arg.parse(word)
+		if err != nil {
+			return nil, err
+		}
+		args.values[arg.Name] = v
+	}
+	return args, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+var testCommand = &CommandDef{
+	Name: "test",
+	Args: []ArgDef{
+		fileArg("file"),
+		intArg("line"),
+		optional(enumArg("style", "exact", "fuzzy")),
+	},
+	Options:        []ArgDef{boolArg("doc"), intArg("limit")},
+	CompileOptions: true,
+}
+
+var testPayloadCommand = &CommandDef{
+	Name:    "test-payload",
+	Args:    []ArgDef{fileArg("file"), intArg("nbytes")},
+	Payload: true,
+}
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		cmd     *CommandDef
+		words   []string
+		values  map[string]interface{}
+		flags   []string
+		payload string
+		// err is the kind of the expected error.
+		err string
+	}{
+		{testCommand, []string{"test", "a.c", "3"},
+			map[string]interface{}{"file": "a.c", "line": uint32(3)}, nil, "", ""},
+		{testCommand, []string{"test", "a.c", "3", "fuzzy"},
+			map[string]interface{}{"file": "a.c", "line": uint32(3), "style": "fuzzy"}, nil, "", ""},
+		{testCommand, []string{"test", "a.c"}, nil, nil, "", "invalid-argument-count"},
+		{testCommand, []string{"test", "a.c", "3", "fuzzy", "x"}, nil, nil, "", "invalid-argument-count"},
+		{testCommand, []string{"test", "a.c", "x"}, nil, nil, "", "invalid-integer"},
+		{testCommand, []string{"test", "a.c", "3", "bogus"}, nil, nil, "", "invalid-argument"},
+		{testCommand, []string{"test", "--doc", "a.c", "--limit=5", "3"},
+			map[string]interface{}{"file": "a.c", "line": uint32(3), "doc": true, "limit": uint32(5)}, nil, "", ""},
+		{testCommand, []string{"test", "a.c", "3", "--doc=off"},
+			map[string]interface{}{"file": "a.c", "line": uint32(3), "doc": false}, nil, "", ""},
+		{testCommand, []string{"test", "a.c", "3", "--limit"}, nil, nil, "", "invalid-argument"},
+		{testCommand, []string{"test", "a.c", "3", "--bogus"}, nil, nil, "", "invalid-argument"},
+		{testCommand, []string{"test", "a.c", "3", "--", "-I.", "--doc"},
+			map[string]interface{}{"file": "a.c", "line": uint32(3)}, []string{"-I.", "--doc"}, "", ""},
+		{testCommand, []string{"test", "a.c", "--", "3"}, nil, nil, "", "invalid-argument-count"},
+		{testPayloadCommand, []string{"test-payload", "a.c", "5", "hello"},
+			map[string]interface{}{"file": "a.c", "nbytes": uint32(5)}, nil, "hello", ""},
+		{testPayloadCommand, []string{"test-payload", "a.c", "0", ""},
+			map[string]interface{}{"file": "a.c", "nbytes": uint32(0)}, nil, "", ""},
+		{testPayloadCommand, []string{"test-payload"}, nil, nil, "", "invalid-argument-count"},
+	}
+	for _, test := range tests {
+		args, err := test.cmd.parseArgs(test.words)
+		if test.err != "" {
+			if err == nil {
+				t.Errorf("parseArgs(%q) succeeded, want %s", test.words, test.err)
+			} else if kind, _, _ := errorParts(err); kind != test.err {
+				t.Errorf("parseArgs(%q) failed with %s, want %s", test.words, kind, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseArgs(%q) failed: %s", test.words, err)
+			continue
+		}
+		if !reflect.DeepEqual(args.values, test.values) {
+			t.Errorf("parseArgs(%q) values = %v, want %v", test.words, args.values, test.values)
+		}
+		if !reflect.DeepEqual(args.flags, test.flags) {
+			t.Errorf("parseArgs(%q) flags = %q, want %q", test.words, args.flags, test.flags)
+		}
+		if args.payload != test.payload {
+			t.Errorf("parseArgs(%q) payload = %q, want %q", test.words, args.payload, test.payload)
+		}
+	}
+}
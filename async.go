@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/kangjianbin/irony-server/irony"
+	"github.com/kangjianbin/irony-server/logger"
+)
+
+// Asynchronous interactive mode. Every request line is prefixed with a
+// client chosen id, "ID COMMAND ARGS...", and its response is terminated
+// by ";;EOT ID". Commands run in order on a worker goroutine while the
+// reader keeps accepting requests into an unbounded queue, so it never
+// blocks and "cancel ID" can drop a pending request. A request that is
+// already running cannot be cancelled, it runs to completion. A new
+// complete request for a file supersedes the pending ones for the same
+// file.
+
+type asyncRequest struct {
+	id    string
+	words []string
+	err   error
+	// cancelled and running are guarded by asyncRunner.mu.
+	cancelled bool
+	running   bool
+}
+
+type asyncRunner struct {
+	s   *session
+	buf *bytes.Buffer
+	out io.Writer
+
+	mu        sync.Mutex
+	ready     *sync.Cond
+	queue     []*asyncRequest
+	closed    bool
+	pending   map[string]*asyncRequest
+	completes map[string]*asyncRequest
+}
+
+// noRequestID tags responses to lines whose id could not be read.
+const noRequestID = "-"
+
+func runAsyncCommands(cache *irony.TUCache, format string, in io.Reader, out io.Writer) {
+	buf := &bytes.Buffer{}
+	r := &asyncRunner{
+		s:         newSharedSession(cache, newResponseEncoder(format, buf)),
+		buf:       buf,
+		out:       out,
+		pending:   make(map[string]*asyncRequest),
+		completes: make(map[string]*asyncRequest),
+	}
+	r.ready = sync.NewCond(&r.mu)
+	unregister := atExit(r.s.ir.Close)
+	done := make(chan struct{})
+	go func() {
+		r.work()
+		close(done)
+	}()
+	r.read(getCmdFromReaderFunc(in, 1))
+	r.close()
+	<-done
+
+	cmdLock.RLock()
+	unregister()
+	r.s.ir.Close()
+	cmdLock.RUnlock()
+}
+
+func (r *asyncRunner) read(nextCmd func() ([]string, error)) {
+	for {
+		words, err := nextCmd()
+		if _, ok := err.(*commandError); ok {
+			r.push(&asyncRequest{id: noRequestID, err: err})
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				logger.Info("Read command: %s\n", err)
+			}
+			return
+		}
+		if words[0] == "cancel" {
+			if len(words) != 2 {
+				err := &commandError{"invalid-argument-count", "invalid number of arguments",
+					[]interface{}{"cancel", "ID"}}
+				r.push(&asyncRequest{id: noRequestID, err: err})
+				continue
+			}
+			r.cancel(words[1])
+			continue
+		}
+		req := &asyncRequest{id: words[0], words: words[1:]}
+		if len(req.words) == 0 {
+			req.err = &commandError{"invalid-command", "missing command", []interface{}{req.id}}
+		}
+		r.enqueue(req)
+		if req.err == nil && req.words[0] == "exit" {
+			return
+		}
+	}
+}
+
+// push queues req for the worker, it never blocks.
+func (r *asyncRunner) push(req *asyncRequest) {
+	r.mu.Lock()
+	r.queue = append(r.queue, req)
+	r.ready.Signal()
+	r.mu.Unlock()
+}
+
+// close lets the worker return once the queue is drained.
+func (r *asyncRunner) close() {
+	r.mu.Lock()
+	r.closed = true
+	r.ready.Signal()
+	r.mu.Unlock()
+}
+
+// next waits for the next request and marks it running, it returns nil
+// once the runner is closed and the queue is drained.
+func (r *asyncRunner) next() *asyncRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for len(r.queue) == 0 && !r.closed {
+		r.ready.Wait()
+	}
+	if len(r.queue) == 0 {
+		return nil
+	}
+	req := r.queue[0]
+	r.queue[0] = nil
+	r.queue = r.queue[1:]
+	req.running = !req.cancelled
+	return req
+}
+
+func (r *asyncRunner) enqueue(req *asyncRequest) {
+	r.mu.Lock()
+	r.pending[req.id] = req
+	if req.err == nil && req.words[0] == "complete" && len(req.words) > 1 {
+		file := req.words[1]
+		if prev, ok := r.completes[file]; ok && !prev.running {
+			logger.Debug("Request %s supersedes %s\n", req.id, prev.id)
+			prev.cancelled = true
+		}
+		r.completes[file] = req
+	}
+	r.mu.Unlock()
+	r.push(req)
+}
+
+func (r *asyncRunner) cancel(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	req, ok := r.pending[id]
+	switch {
+	case !ok:
+		logger.Info("Cancel: no pending request %s\n", id)
+	case req.running:
+		logger.Info("Cancel: request %s is already running\n", id)
+	default:
+		logger.Debug("Cancel request %s\n", id)
+		req.cancelled = true
+	}
+}
+
+func (r *asyncRunner) isCancelled(req *asyncRequest) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return req.cancelled
+}
+
+func (r *asyncRunner) finish(req *asyncRequest) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending[req.id] == req {
+		delete(r.pending, req.id)
+	}
+	for file, cmpl := range r.completes {
+		if cmpl == req {
+			delete(r.completes, file)
+		}
+	}
+}
+
+func (r *asyncRunner) work() {
+	cmdMap := commandMap()
+	for req := r.next(); req != nil; req = r.next() {
+		r.buf.Reset()
+		err := req.err
+		if r.isCancelled(req) {
+			err = irony.NewError("cancelled", "request cancelled", req.id)
+		} else if err == nil {
+			err = runCommand(r.s, cmdMap, req.words)
+		}
+		if err == errExit {
+			r.finish(req)
+			continue
+		}
+		if err != nil {
+			r.s.enc.Error(err)
+		}
+		r.finish(req)
+		r.out.Write(r.buf.Bytes())
+		io.WriteString(r.out, "\n;;EOT "+req.id+"\n")
+	}
+}
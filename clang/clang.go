@@ -1,4 +1,5 @@
-package main
+// Package clang is the cgo binding of the libclang API used by irony-server.
+package clang
 
 // #cgo LDFLAGS: -lclang
 // #include <clang-c/Index.h>
@@ -6,6 +7,7 @@ package main
 // #include <stdlib.h>
 import "C"
 import "reflect"
+import "time"
 import "unsafe"
 
 func DefaultEditingTranslationUnitOptions() uint32 {
@@ -186,6 +188,11 @@ func (f File) Name() string {
 	return o.String()
 }
 
+// The last modification time of the file, as read by libclang.
+func (f File) Time() time.Time {
+	return time.Unix(int64(C.clang_getFileTime(f.c)), 0)
+}
+
 func (ccr *CodeCompleteResults) Results() []CompletionResult {
 	var s []CompletionResult
 	gos_s := (*reflect.SliceHeader)(unsafe.Pointer(&s))
@@ -196,10 +203,45 @@ func (ccr *CodeCompleteResults) Results() []CompletionResult {
 	return s
 }
 
+func (ccr *CodeCompleteResults) NumDiagnostics() uint32 {
+	return uint32(C.clang_codeCompleteGetNumDiagnostics(ccr.c))
+}
+
+func (ccr *CodeCompleteResults) Diagnostic(index uint32) Diagnostic {
+	return Diagnostic{C.clang_codeCompleteGetDiagnostic(ccr.c, C.uint(index))}
+}
+
+// The kinds of completions that are appropriate for the context of the results.
+func (ccr *CodeCompleteResults) Contexts() CompletionContext {
+	return CompletionContext(C.clang_codeCompleteGetContexts(ccr.c))
+}
+
+// The kind of the entity that contains the completion context, and whether
+// libclang could not fully figure out that entity. It is Cursor_InvalidCode
+// when there is no container.
+func (ccr *CodeCompleteResults) ContainerKind() (CursorKind, bool) {
+	var incomplete C.uint
+	o := C.clang_codeCompleteGetContainerKind(ccr.c, &incomplete)
+	return CursorKind(o), incomplete != 0
+}
+
+// The USR of the entity that contains the completion context.
+func (ccr *CodeCompleteResults) ContainerUSR() string {
+	o := cxstring{C.clang_codeCompleteGetContainerUSR(ccr.c)}
+	defer o.Dispose()
+
+	return o.String()
+}
+
 func (ccr *CodeCompleteResults) Dispose() {
 	C.clang_disposeCodeCompleteResults(ccr.c)
 }
 
+// The kind of entity that this completion refers to.
+func (cr CompletionResult) CursorKind() CursorKind {
+	return CursorKind(cr.c.CursorKind)
+}
+
 // The code-completion string that describes how to insert this code-completion result into the editing buffer.
 func (cr CompletionResult) CompletionString() CompletionString {
 	return CompletionString{cr.c.CompletionString}
@@ -228,11 +270,57 @@ func (cs CompletionString) ChunkText(chunkNumber uint32) string {
 	return o.String()
 }
 
+// The brief documentation comment attached to the declaration of the completion result.
+func (cs CompletionString) BriefComment() string {
+	o := cxstring{C.clang_getCompletionBriefComment(cs.c)}
+	defer o.Dispose()
+
+	return o.String()
+}
+
+// The name of the parent context of the completion result, e.g. the class of a member.
+func (cs CompletionString) Parent() string {
+	o := cxstring{C.clang_getCompletionParent(cs.c, nil)}
+	defer o.Dispose()
+
+	return o.String()
+}
+
+// The cursor of the translation unit, its children are the top-level declarations.
+func (tu TranslationUnit) TranslationUnitCursor() Cursor {
+	return Cursor{C.clang_getTranslationUnitCursor(tu.c)}
+}
+
 func (c Cursor) IsNull() bool {
 	o := C.clang_Cursor_isNull(c.c)
 	return o != C.int(0)
 }
 
+func (c Cursor) Kind() CursorKind {
+	return CursorKind(C.clang_getCursorKind(c.c))
+}
+
+func (c Cursor) Spelling() string {
+	o := cxstring{C.clang_getCursorSpelling(c.c)}
+	defer o.Dispose()
+
+	return o.String()
+}
+
+// The cursor of the declaration that semantically contains the cursor, e.g.
+// the class of an out-of-line method definition.
+func (c Cursor) SemanticParent() Cursor {
+	return Cursor{C.clang_getCursorSemanticParent(c.c)}
+}
+
+// The raw text of the comment attached to the declaration of the cursor, with its comment markers.
+func (c Cursor) RawCommentText() string {
+	o := cxstring{C.clang_Cursor_getRawCommentText(c.c)}
+	defer o.Dispose()
+
+	return o.String()
+}
+
 func (c Cursor) Type() Type {
 	return Type{C.clang_getCursorType(c.c)}
 }
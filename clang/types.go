@@ -1,4 +1,4 @@
-package main
+package clang
 
 // #cgo LDFLAGS: -lclang
 // #include <clang-c/Index.h>
@@ -328,6 +328,138 @@ const (
 	CompletionChunk_VerticalSpace = C.CXCompletionChunk_VerticalSpace
 )
 
+type CursorKind uint32
+
+const (
+	Cursor_UnexposedDecl                      CursorKind = C.CXCursor_UnexposedDecl
+	Cursor_StructDecl                                    = C.CXCursor_StructDecl
+	Cursor_UnionDecl                                     = C.CXCursor_UnionDecl
+	Cursor_ClassDecl                                     = C.CXCursor_ClassDecl
+	Cursor_EnumDecl                                      = C.CXCursor_EnumDecl
+	Cursor_FieldDecl                                     = C.CXCursor_FieldDecl
+	Cursor_EnumConstantDecl                              = C.CXCursor_EnumConstantDecl
+	Cursor_FunctionDecl                                  = C.CXCursor_FunctionDecl
+	Cursor_VarDecl                                       = C.CXCursor_VarDecl
+	Cursor_ParmDecl                                      = C.CXCursor_ParmDecl
+	Cursor_ObjCInterfaceDecl                             = C.CXCursor_ObjCInterfaceDecl
+	Cursor_ObjCCategoryDecl                              = C.CXCursor_ObjCCategoryDecl
+	Cursor_ObjCProtocolDecl                              = C.CXCursor_ObjCProtocolDecl
+	Cursor_ObjCPropertyDecl                              = C.CXCursor_ObjCPropertyDecl
+	Cursor_ObjCIvarDecl                                  = C.CXCursor_ObjCIvarDecl
+	Cursor_ObjCInstanceMethodDecl                        = C.CXCursor_ObjCInstanceMethodDecl
+	Cursor_ObjCClassMethodDecl                           = C.CXCursor_ObjCClassMethodDecl
+	Cursor_ObjCImplementationDecl                        = C.CXCursor_ObjCImplementationDecl
+	Cursor_ObjCCategoryImplDecl                          = C.CXCursor_ObjCCategoryImplDecl
+	Cursor_TypedefDecl                                   = C.CXCursor_TypedefDecl
+	Cursor_CXXMethod                                     = C.CXCursor_CXXMethod
+	Cursor_Namespace                                     = C.CXCursor_Namespace
+	Cursor_LinkageSpec                                   = C.CXCursor_LinkageSpec
+	Cursor_Constructor                                   = C.CXCursor_Constructor
+	Cursor_Destructor                                    = C.CXCursor_Destructor
+	Cursor_ConversionFunction                            = C.CXCursor_ConversionFunction
+	Cursor_TemplateTypeParameter                         = C.CXCursor_TemplateTypeParameter
+	Cursor_NonTypeTemplateParameter                      = C.CXCursor_NonTypeTemplateParameter
+	Cursor_TemplateTemplateParameter                     = C.CXCursor_TemplateTemplateParameter
+	Cursor_FunctionTemplate                              = C.CXCursor_FunctionTemplate
+	Cursor_ClassTemplate                                 = C.CXCursor_ClassTemplate
+	Cursor_ClassTemplatePartialSpecialization            = C.CXCursor_ClassTemplatePartialSpecialization
+	Cursor_NamespaceAlias                                = C.CXCursor_NamespaceAlias
+	Cursor_UsingDirective                                = C.CXCursor_UsingDirective
+	Cursor_UsingDeclaration                              = C.CXCursor_UsingDeclaration
+	Cursor_TypeAliasDecl                                 = C.CXCursor_TypeAliasDecl
+	Cursor_ObjCSynthesizeDecl                            = C.CXCursor_ObjCSynthesizeDecl
+	Cursor_ObjCDynamicDecl                               = C.CXCursor_ObjCDynamicDecl
+	Cursor_CXXAccessSpecifier                            = C.CXCursor_CXXAccessSpecifier
+	// Code completion uses it for keywords and code patterns.
+	Cursor_NotImplemented        = C.CXCursor_NotImplemented
+	Cursor_InvalidCode           = C.CXCursor_InvalidCode
+	Cursor_MacroDefinition       = C.CXCursor_MacroDefinition
+	Cursor_TypeAliasTemplateDecl = C.CXCursor_TypeAliasTemplateDecl
+)
+
+// IsDeclaration reports whether the kind is a declaration.
+func (k CursorKind) IsDeclaration() bool {
+	return C.clang_isDeclaration(C.enum_CXCursorKind(k)) != 0
+}
+
+// Spelling returns the name libclang gives to the kind, e.g. "StructDecl".
+func (k CursorKind) Spelling() string {
+	o := cxstring{C.clang_getCursorKindSpelling(C.enum_CXCursorKind(k))}
+	defer o.Dispose()
+
+	return o.String()
+}
+
+// CompletionContext is a mask of the kinds of results a completion may
+// offer.
+type CompletionContext uint64
+
+const (
+	// The context for completions is unexposed, as only Clang results should be included.
+	CompletionContext_Unexposed CompletionContext = C.CXCompletionContext_Unexposed
+	// Completions for any possible type should be included in the results.
+	CompletionContext_AnyType = C.CXCompletionContext_AnyType
+	// Completions for any possible value (variables, function calls, etc.) should be included in the results.
+	CompletionContext_AnyValue = C.CXCompletionContext_AnyValue
+	// Completions for values that resolve to an Objective-C object should be included in the results.
+	CompletionContext_ObjCObjectValue = C.CXCompletionContext_ObjCObjectValue
+	// Completions for values that resolve to an Objective-C selector should be included in the results.
+	CompletionContext_ObjCSelectorValue = C.CXCompletionContext_ObjCSelectorValue
+	// Completions for values that resolve to a C++ class type should be included in the results.
+	CompletionContext_CXXClassTypeValue = C.CXCompletionContext_CXXClassTypeValue
+	// Completions for fields of the member being accessed using the dot operator should be included in the results.
+	CompletionContext_DotMemberAccess = C.CXCompletionContext_DotMemberAccess
+	// Completions for fields of the member being accessed using the arrow operator should be included in the results.
+	CompletionContext_ArrowMemberAccess = C.CXCompletionContext_ArrowMemberAccess
+	// Completions for properties of the Objective-C object being accessed using the dot operator should be included in the results.
+	CompletionContext_ObjCPropertyAccess = C.CXCompletionContext_ObjCPropertyAccess
+	// Completions for enum tags should be included in the results.
+	CompletionContext_EnumTag = C.CXCompletionContext_EnumTag
+	// Completions for union tags should be included in the results.
+	CompletionContext_UnionTag = C.CXCompletionContext_UnionTag
+	// Completions for struct tags should be included in the results.
+	CompletionContext_StructTag = C.CXCompletionContext_StructTag
+	// Completions for C++ class names should be included in the results.
+	CompletionContext_ClassTag = C.CXCompletionContext_ClassTag
+	// Completions for C++ namespaces and namespace aliases should be included in the results.
+	CompletionContext_Namespace = C.CXCompletionContext_Namespace
+	// Completions for C++ nested name specifiers should be included in the results.
+	CompletionContext_NestedNameSpecifier = C.CXCompletionContext_NestedNameSpecifier
+	// Completions for Objective-C interfaces (classes) should be included in the results.
+	CompletionContext_ObjCInterface = C.CXCompletionContext_ObjCInterface
+	// Completions for Objective-C protocols should be included in the results.
+	CompletionContext_ObjCProtocol = C.CXCompletionContext_ObjCProtocol
+	// Completions for Objective-C categories should be included in the results.
+	CompletionContext_ObjCCategory = C.CXCompletionContext_ObjCCategory
+	// Completions for Objective-C instance messages should be included in the results.
+	CompletionContext_ObjCInstanceMessage = C.CXCompletionContext_ObjCInstanceMessage
+	// Completions for Objective-C class messages should be included in the results.
+	CompletionContext_ObjCClassMessage = C.CXCompletionContext_ObjCClassMessage
+	// Completions for Objective-C selector names should be included in the results.
+	CompletionContext_ObjCSelectorName = C.CXCompletionContext_ObjCSelectorName
+	// Completions for preprocessor macro names should be included in the results.
+	CompletionContext_MacroName = C.CXCompletionContext_MacroName
+	// Natural language completions should be included in the results.
+	CompletionContext_NaturalLanguage = C.CXCompletionContext_NaturalLanguage
+	// #include file completions should be included in the results. The
+	// bit is only defined by the headers of libclang 6 and later, older
+	// libraries never set it.
+	CompletionContext_IncludedFile = 1 << 22
+	// The current context is unknown, so set all contexts.
+	CompletionContext_Unknown = C.CXCompletionContext_Unknown
+)
+
+type ChildVisitResult uint32
+
+const (
+	// Terminates the cursor traversal.
+	ChildVisit_Break ChildVisitResult = C.CXChildVisit_Break
+	// Continues the cursor traversal with the next sibling of the cursor just visited, without visiting its children.
+	ChildVisit_Continue = C.CXChildVisit_Continue
+	// Recursively traverse the children of this cursor, using the same visitor and client data.
+	ChildVisit_Recurse = C.CXChildVisit_Recurse
+)
+
 type CompilationDatabase struct {
 	c C.CXCompilationDatabase
 }
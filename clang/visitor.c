@@ -0,0 +1,13 @@
+#include <clang-c/Index.h>
+#include "_cgo_export.h"
+
+// goClangVisitChildren visits the children of parent with the exported Go
+// visitor, cgo cannot pass a Go function as a C function pointer.
+unsigned goClangVisitChildren(CXCursor parent, CXClientData data) {
+	return clang_visitChildren(parent, goClangCursorVisitor, data);
+}
+
+// goClangGetInclusions visits the files of tu with the exported Go visitor.
+void goClangGetInclusions(CXTranslationUnit tu, CXClientData data) {
+	clang_getInclusions(tu, goClangInclusionVisitor, data);
+}
@@ -0,0 +1,49 @@
+package clang
+
+// #include <clang-c/Index.h>
+// unsigned goClangVisitChildren(CXCursor parent, CXClientData data);
+// void goClangGetInclusions(CXTranslationUnit tu, CXClientData data);
+import "C"
+import (
+	"runtime/cgo"
+	"unsafe"
+)
+
+// CursorVisitor is called for each child of a visited cursor, its result
+// says how to go on.
+type CursorVisitor func(cursor, parent Cursor) ChildVisitResult
+
+// Visit calls visitor on the children of c. It reports whether the
+// traversal was terminated by ChildVisit_Break.
+func (c Cursor) Visit(visitor CursorVisitor) bool {
+	h := cgo.NewHandle(visitor)
+	defer h.Delete()
+	return C.goClangVisitChildren(c.c, C.CXClientData(unsafe.Pointer(&h))) != 0
+}
+
+//export goClangCursorVisitor
+func goClangCursorVisitor(cursor, parent C.CXCursor, data C.CXClientData) C.enum_CXChildVisitResult {
+	visitor := (*(*cgo.Handle)(data)).Value().(CursorVisitor)
+	return C.enum_CXChildVisitResult(visitor(Cursor{cursor}, Cursor{parent}))
+}
+
+// InclusionVisitor is called for each file of a TU with the inclusion
+// stack leading to it, empty for the main file.
+type InclusionVisitor func(includedFile File, inclusionStack []SourceLocation)
+
+// Inclusions calls visitor on the files of the TU.
+func (tu TranslationUnit) Inclusions(visitor InclusionVisitor) {
+	h := cgo.NewHandle(visitor)
+	defer h.Delete()
+	C.goClangGetInclusions(tu.c, C.CXClientData(unsafe.Pointer(&h)))
+}
+
+//export goClangInclusionVisitor
+func goClangInclusionVisitor(file C.CXFile, stack *C.CXSourceLocation, n C.uint, data C.CXClientData) {
+	visitor := (*(*cgo.Handle)(data)).Value().(InclusionVisitor)
+	locs := make([]SourceLocation, int(n))
+	for i, c := range unsafe.Slice(stack, int(n)) {
+		locs[i] = SourceLocation{c}
+	}
+	visitor(File{file}, locs)
+}
@@ -2,119 +2,219 @@ package main
 
 import (
 	"fmt"
-	"os"
 	"strconv"
 	"strings"
+
+	"github.com/kangjianbin/irony-server/irony"
+	"github.com/kangjianbin/irony-server/logger"
 )
 
 const (
 	myApp = "irony-server"
+	// protocolVersion is bumped on incompatible changes of the commands
+	// or of their responses.
+	protocolVersion = 1
 )
 
-type cliCommandReader struct {
-	args []string
-}
-
-type stdinCommandReader struct {
-}
+// serverModes are the ways of talking to the server, see printHelp.
+var serverModes = []string{"interactive", "async", "lsp", "jsonrpc", "listen", "http", "script", "record", "replay"}
 
+// CommandDef describes a command. Its argument schema drives validation,
+// the help output and the JSON-RPC params.
 type CommandDef struct {
 	Name string
 	Desc string
-	Run  func(*Irony, []string) error
+	Args []ArgDef
+	// Options are given anywhere before the compile options as
+	// --NAME=VALUE, or --NAME for a boolean turned on.
+	Options []ArgDef
+	// CompileOptions accepts [-- [COMPILE_OPTIONS...]] after Args.
+	CompileOptions bool
+	// Payload commands end with an "nbytes" argument, the content is
+	// read from the input right after the command line.
+	Payload bool
+	// Requires names the command whose state this one reads, "parse" or
+	// "complete". The HTTP front end reports a missing state as an error.
+	Requires string
+	Run      func(*session, *commandArgs) error
 }
 
+// commandError reports a malformed command to the client as
+// (error . (kind "err" args...)).
 type commandError struct {
-	err string
+	kind string
+	err  string
+	args []interface{}
 }
 
 func (c *commandError) Error() string {
 	return c.err
 }
 
+func errUnknownCommand(name string) *commandError {
+	return &commandError{"invalid-command", "unknown command", []interface{}{name}}
+}
+
+func errArgCount(cmd *CommandDef) *commandError {
+	return &commandError{"invalid-argument-count", "invalid number of arguments",
+		[]interface{}{cmd.Name, cmd.usage()}}
+}
+
+func errInteger(what string, arg string) *commandError {
+	return &commandError{"invalid-integer", what + " isn't an integer", []interface{}{arg}}
+}
+
+func errArgument(msg string, arg string) *commandError {
+	return &commandError{"invalid-argument", msg, []interface{}{arg}}
+}
+
 var Commands []*CommandDef
 
+func commandMap() map[string]*CommandDef {
+	cmdMap := make(map[string]*CommandDef)
+	for _, cmd := range Commands {
+		cmdMap[cmd.Name] = cmd
+	}
+	return cmdMap
+}
+
 func initCommands() {
 	Commands = []*CommandDef{
 		&CommandDef{
-			"help",
-			"show this message",
-			cmdHelp,
+			Name: "help",
+			Desc: "show this message",
+			Run:  cmdHelp,
+		},
+		&CommandDef{
+			Name: "candidates",
+			Desc: "print completion candidates (require previous complete), at most max-candidates " +
+				"if set, with --limit or --offset the page and its total, " +
+				"KINDS selects kinds or groups of kinds, e.g. members,types,function",
+			Args: []ArgDef{
+				optional(stringArg("prefix")),
+				optional(lenient(enumArg("style", irony.MatchingStyles...))),
+			},
+			Options:  []ArgDef{boolArg("doc"), intArg("limit"), intArg("offset"), stringArg("kinds")},
+			Requires: "complete",
+			Run:      cmdCandidates,
+		},
+		&CommandDef{
+			Name:           "complete",
+			Desc:           "perform code completion at a give location",
+			Args:           []ArgDef{fileArg("file"), intArg("line"), intArg("column")},
+			CompileOptions: true,
+			Run:            cmdComplete,
+		},
+		&CommandDef{
+			Name:     "completion-context",
+			Desc:     "print the context kinds and the container of the completion point (require previous complete)",
+			Requires: "complete",
+			Run:      cmdCompletionContext,
+		},
+		&CommandDef{
+			Name:     "completion-diagnostics",
+			Desc:     "print the diagnostics generated during complete",
+			Requires: "complete",
+			Run:      cmdCompletionDiagnostics,
+		},
+		&CommandDef{
+			Name:     "diagnostics",
+			Desc:     "print the diagnostics of the last parse",
+			Requires: "parse",
+			Run:      cmdDiagnostics,
 		},
 		&CommandDef{
-			"candidates",
-			"print completion candidates (require previous complete)",
-			cmdCandidates,
+			Name: "edit-unsaved",
+			Desc: "replace the given range of FILE with the NBYTES bytes following the command",
+			Args: []ArgDef{fileArg("file"), intArg("version"),
+				intArg("start_line"), intArg("start_column"),
+				intArg("end_line"), intArg("end_column"), intArg("nbytes")},
+			Payload: true,
+			Run:     cmdEditUnsaved,
 		},
 		&CommandDef{
-			"complete",
-			"FILE LINE COL [-- [COMPILE_OPTIONS...]] - perform code completion at a give location",
-			cmdComplete,
+			Name: "exit",
+			Desc: "exit interactive mode, print nothing",
+			Run:  cmdExit,
 		},
 		&CommandDef{
-			"completion-diagnostics",
-			"print the diagnostics generated  during complete",
-			nil,
+			Name: "get-compile-options",
+			Desc: "get compile options for FILE from JSON database in BUILD_DIR",
+			Args: []ArgDef{pathArg("build_dir"), fileArg("file")},
+			Run:  cmdGetCompileOptions,
 		},
 		&CommandDef{
-			"diagnostics",
-			"print the diagnostics of the last parse",
-			cmdDiagnostics,
+			Name:     "get-type",
+			Desc:     "get type of symbol at a given location",
+			Args:     []ArgDef{intArg("line"), intArg("column")},
+			Requires: "parse",
+			Run:      cmdGetType,
 		},
 		&CommandDef{
-			"exit",
-			"exit interactive mode, print nothing",
-			cmdExit,
+			Name:           "parse",
+			Desc:           "parse the given file",
+			Args:           []ArgDef{fileArg("file")},
+			CompileOptions: true,
+			Run:            cmdParse,
 		},
 		&CommandDef{
-			"get-compile-options",
-			"BUILD_DIR FILE - get compile options for FILE from JSON database in PROJECT_ROOT",
-			cmdGetCompileOptions,
+			Name: "reset-unsaved",
+			Desc: "reset FILE, its content is up to date",
+			Args: []ArgDef{fileArg("file")},
+			Run:  cmdResetUnsaved,
 		},
 		&CommandDef{
-			"get-type",
-			"LINE COL -get type of symbol at a given location",
-			cmdGetType,
+			Name: "server-capabilities",
+			Desc: "print the protocol version, the commands and the features of the server",
+			Run:  cmdServerCapabilities,
 		},
 		&CommandDef{
-			"parse",
-			"FILE [-- [COMPILE_OPTIONS...]] - parse the given file",
-			cmdParse,
+			Name: "show-config",
+			Desc: "print the effective configuration, of FILE if given, else of the last parsed file",
+			Args: []ArgDef{optional(fileArg("file"))},
+			Run:  cmdShowConfig,
 		},
 		&CommandDef{
-			"reset-unsaved",
-			"FILE - reset FILE, its content is up to date",
-			cmdResetUnsaved,
+			Name: "set-debug",
+			Desc: "enable or disable verbose logging",
+			Args: []ArgDef{lenient(boolArg("value"))},
+			Run:  cmdSetDebug,
 		},
 		&CommandDef{
-			"set-debug",
-			"[on/off] - enable or disable verbose logging",
-			cmdSetDebug,
+			Name: "set-unsaved",
+			Desc: "tell irony-server that UNSAVED contains the effective content of FILE",
+			Args: []ArgDef{fileArg("file"), pathArg("unsaved")},
+			Run:  cmdSetUnsaved,
 		},
 		&CommandDef{
-			"set-unsaved",
-			"FILE UNSAVE - tell irony-server that UNSAVED contains the effective content of FILE",
-			cmdSetUnsaved,
+			Name:    "set-unsaved-inline",
+			Desc:    "set the effective content of FILE to the NBYTES bytes following the command",
+			Args:    []ArgDef{fileArg("file"), intArg("nbytes")},
+			Payload: true,
+			Run:     cmdSetUnsavedInline,
 		},
 	}
 }
 
-func printHelp() {
-	usageMsg := fmt.Sprintf(
-		`usage: %s [OPTIONS...] [COMMAND] [ARGS...]
-
-Options:
-  -v, --version
-  -h, --help
-  -i, --interactive
-  -d, --debug
-  --log-file PATH
-  Commands:`, myApp)
-	fmt.Println(usageMsg)
+// helpText returns the usage of the server, the options and the commands.
+func helpText() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "usage: %s [OPTIONS...] [COMMAND] [ARGS...]\n", myApp)
+	fmt.Fprintf(&b, "       %s replay DIR\n", myApp)
+	fmt.Fprintf(&b, "       %s completion SHELL\n", myApp)
+	fmt.Fprintf(&b, "\nOptions:\n%s", optionsHelp())
+	fmt.Fprintf(&b, "\n%s\n", configHelp)
+	fmt.Fprintln(&b, "  Commands:")
 	for _, cmd := range Commands {
-		fmt.Printf("%-25s %s\n", cmd.Name, cmd.Desc)
+		fmt.Fprintf(&b, "%-25s %s\n", cmd.Name, cmd.help())
 	}
+	return b.String()
+}
 
+// printHelp prints the help of -h and --help, the help command answers
+// through the encoder of its session instead.
+func printHelp() {
+	fmt.Print(helpText())
 }
 
 func parseUint(arg string) (uint32, error) {
@@ -132,164 +232,169 @@ func parseBool(arg string) (bool, error) {
 	if arg == "off" {
 		return false, nil
 	}
-	return false, &commandError{"Invalid boolean value"}
+	return false, errArgument("invalid boolean value", arg)
 }
 
-func cmdHelp(*Irony, []string) error {
-	printHelp()
+func cmdHelp(s *session, args *commandArgs) error {
+	s.enc.Help(helpText())
 	return nil
 }
 
 func fixupFileName(filename string) string {
 	if filename == "-" {
 		filename = getTempFilePath()
-		logDebug("Convert - to %s\n", filename)
+		logger.Debug("Convert - to %s\n", filename)
 	}
 	return filename
 }
 
-func readCompileOptions(args []string) []string {
-	var i int
-	for i := 0; i < len(args); i += 1 {
-		if args[i] == "--" {
-			break
-		}
-	}
-	if i >= len(args) {
-		return nil
-	}
-	return args[i+1:]
-}
-
 func dumpFlags(info string, file string, flags []string) {
 	var s string
 	for _, flag := range flags {
 		s += fmt.Sprintf("`%s` ", flag)
 	}
-	logDebug("%s: file: %s, flags: %s\n", info, file, s)
+	logger.Debug("%s: file: %s, flags: %s\n", info, file, s)
 }
 
-func cmdExit(*Irony, []string) error {
-	os.Exit(0)
+// errExit is returned by the exit command to stop the command loop.
+var errExit = &commandError{"exit", "exit", nil}
+
+func cmdExit(*session, *commandArgs) error {
+	return errExit
+}
+
+func cmdGetCompileOptions(s *session, args *commandArgs) error {
+	s.GetCompileOptions(args.str("build_dir"), args.str("file"))
 	return nil
 }
 
-func cmdGetCompileOptions(ir *Irony, args []string) error {
-	if len(args) != 3 {
-		return &commandError{"Invalid arguments number"}
-	}
-	buildDir, file := args[1], fixupFileName(args[2])
-	ir.GetCompileOptions(buildDir, file)
+func cmdParse(s *session, args *commandArgs) error {
+	file := args.str("file")
+	dumpFlags("parse", file, args.flags)
+	s.Parse(file, args.flags)
 	return nil
 }
 
-func cmdParse(ir *Irony, args []string) error {
-	if len(args) < 2 {
-		return &commandError{"Invalid argument number"}
-	}
-	file := fixupFileName(args[1])
-	flags := readCompileOptions(args[2:])
-	dumpFlags("parse", file, flags)
-	ir.Parse(file, flags)
+func cmdResetUnsaved(s *session, args *commandArgs) error {
+	s.ResetUnsaved(args.str("file"))
 	return nil
 }
 
-func cmdResetUnsaved(ir *Irony, args []string) error {
-	if len(args) != 2 {
-		return &commandError{"Invalid argument number"}
-	}
-	file := fixupFileName(args[1])
-	ir.ResetUnsaved(file)
+func cmdSetUnsaved(s *session, args *commandArgs) error {
+	s.SetUnsaved(args.str("file"), args.str("unsaved"))
+	return nil
+}
+
+func cmdSetUnsavedInline(s *session, args *commandArgs) error {
+	s.SetUnsavedContent(args.str("file"), args.payload)
 	return nil
 }
 
-func cmdSetUnsaved(ir *Irony, args []string) error {
-	if len(args) != 3 {
-		return &commandError{"Invalid argument number"}
+func cmdEditUnsaved(s *session, args *commandArgs) error {
+	edit := &irony.Edit{
+		StartLine: args.uint("start_line"), StartCol: args.uint("start_column"),
+		EndLine: args.uint("end_line"), EndCol: args.uint("end_column"),
+		Text: args.payload,
 	}
-	file, unsaved := fixupFileName(args[1]), args[2]
-	ir.SetUnsaved(file, unsaved)
+	s.EditUnsaved(args.str("file"), args.uint("version"), edit)
 	return nil
 }
 
-func cmdDiagnostics(ir *Irony, args []string) error {
-	ir.Diagnostics()
+func cmdDiagnostics(s *session, args *commandArgs) error {
+	s.Diagnostics()
 	return nil
 }
 
-func cmdComplete(ir *Irony, args []string) error {
-	if len(args) < 4 {
-		return &commandError{"Invalid argument number"}
-	}
-	file := fixupFileName(args[1])
-	line, err := strconv.ParseUint(args[2], 0, 32)
-	if err != nil {
-		return &commandError{"Line isn't a integer"}
-	}
-	column, err := strconv.ParseUint(args[3], 0, 32)
-	if err != nil {
-		return &commandError{"Column isn't a integer"}
-	}
-	flags := readCompileOptions(args[4:])
-	dumpFlags("complete", file, flags)
-	ir.Complete(file, uint32(line), uint32(column), flags)
+func cmdCompletionDiagnostics(s *session, args *commandArgs) error {
+	s.CompletionDiagnostics()
 	return nil
 }
 
-var prefixMatchMap = map[string]uint{
-	"exact":            PrefixMatchExact,
-	"case-insensitive": PrefixMatchCaseInsensitive,
-	"smart-case":       PrefixMatchSmartCase,
+func cmdComplete(s *session, args *commandArgs) error {
+	file := args.str("file")
+	dumpFlags("complete", file, args.flags)
+	s.Complete(file, args.uint("line"), args.uint("column"), args.flags)
+	return nil
 }
 
 func getMatchingStyle(arg string) uint {
-	if v, ok := prefixMatchMap[arg]; ok {
+	if v, ok := irony.MatchingStyle(arg); ok {
 		return v
 	}
-	return PrefixMatchExact
+	return irony.PrefixMatchExact
 }
 
-func cmdCandidates(ir *Irony, args []string) error {
-	prefix := ""
-	style := PrefixMatchExact
-
-	if len(args) >= 2 {
-		prefix = args[1]
+func cmdCandidates(s *session, args *commandArgs) error {
+	style := s.ir.Config().MatchingStyle
+	if args.has("style") {
+		style = args.str("style")
 	}
-	if len(args) >= 3 {
-		style = getMatchingStyle(args[2])
+	var kinds []string
+	if args.has("kinds") {
+		kinds = []string{}
+		for _, name := range strings.Split(args.str("kinds"), ",") {
+			k, ok := irony.CursorKinds(name)
+			if !ok {
+				return errArgument("unknown kind", name)
+			}
+			kinds = append(kinds, k...)
+		}
 	}
+	// The plain list is only cut by an explicit max-candidates, a page
+	// reports its total and defaults to MaxCandidates.
+	paged := args.has("limit") || args.has("offset")
+	limit := s.ir.Config().MaxCandidates
+	if args.has("limit") {
+		limit = int(args.uint("limit"))
+	} else if paged && limit == 0 {
+		limit = irony.MaxCandidates
+	}
+	s.Candidates(args.str("prefix"), &irony.CandidateOptions{
+		Style:  getMatchingStyle(style),
+		Docs:   args.bool("doc"),
+		Kinds:  kinds,
+		Offset: int(args.uint("offset")),
+		Limit:  limit,
+	}, paged)
+	return nil
+}
 
-	ir.Candidates(prefix, style)
+func cmdCompletionContext(s *session, args *commandArgs) error {
+	s.CompletionContext()
 	return nil
 }
 
-func cmdGetType(ir *Irony, args []string) error {
-	if len(args) < 3 {
-		return &commandError{"Invalid argument number"}
-	}
-	line, err := strconv.ParseUint(args[1], 0, 32)
-	if err != nil {
-		return &commandError{"Invalid line number"}
-	}
-	col, err := strconv.ParseUint(args[2], 0, 32)
-	if err != nil {
-		return &commandError{"Invalid col number"}
-	}
-	ir.GetType(uint32(line), uint32(col))
+func cmdShowConfig(s *session, args *commandArgs) error {
+	s.ShowConfig(args.str("file"))
+	return nil
+}
 
+func cmdGetType(s *session, args *commandArgs) error {
+	s.GetType(args.uint("line"), args.uint("column"))
 	return nil
 }
 
-func cmdSetDebug(ir *Irony, args []string) error {
-	if len(args) < 2 {
-		return &commandError{"Invalid argument number"}
+func serverCapabilities() *capabilitiesInfo {
+	caps := &capabilitiesInfo{
+		ProtocolVersion: protocolVersion,
+		Version:         GetVersion(),
+		ClangVersion:    irony.ClangVersion(),
+		MatchingStyles:  irony.MatchingStyles,
+		Formats:         formatNames(),
+		Modes:           serverModes,
 	}
-	value := strings.ToLower(args[1])
-	isOn := false
-	if value == "on" {
-		isOn = true
+	for _, cmd := range Commands {
+		caps.Commands = append(caps.Commands, cmd.Name)
 	}
-	setDebug(isOn)
+	return caps
+}
+
+func cmdServerCapabilities(s *session, args *commandArgs) error {
+	s.enc.Capabilities(serverCapabilities())
+	return nil
+}
+
+func cmdSetDebug(s *session, args *commandArgs) error {
+	logger.SetDebug(args.bool("value"))
 	return nil
 }
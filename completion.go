@@ -0,0 +1,372 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Shell completion scripts, generated from the Commands registry and the
+// Options table. The completion command prints them, e.g.
+//   source <(irony-server completion bash)
+
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// completionCommands returns the commands to complete, replay and
+// completion are not commands of the registry but modes of their own.
+func completionCommands() []*CommandDef {
+	replay := &CommandDef{
+		Name: "replay",
+		Desc: "replay the session recorded in DIR and print the differing responses",
+		Args: []ArgDef{pathArg("dir")},
+	}
+	completion := &CommandDef{
+		Name: "completion",
+		Desc: "print the completion script of SHELL, e.g. source <(irony-server completion bash)",
+		Args: []ArgDef{enumArg("shell", completionShells...)},
+	}
+	return append(append([]*CommandDef{}, Commands...), replay, completion)
+}
+
+func completionScript(shell string) string {
+	switch shell {
+	case "bash":
+		return bashCompletion()
+	case "zsh":
+		return zshCompletion()
+	case "fish":
+		return fishCompletion()
+	}
+	return ""
+}
+
+// argValues returns the values an argument completes to, nil if it is
+// not an enum.
+func argValues(arg *ArgDef) []string {
+	switch arg.Kind {
+	case argEnum:
+		return arg.Values
+	case argBool:
+		return []string{"on", "off"}
+	}
+	return nil
+}
+
+func isPathArg(arg *ArgDef) bool {
+	return arg.Kind == argFile || arg.Kind == argPath
+}
+
+// optionWords returns the options as completed before the command.
+func optionWords() []string {
+	var words []string
+	for _, opt := range Options {
+		for _, name := range opt.Names {
+			if opt.Joined {
+				name += "="
+			}
+			words = append(words, name)
+		}
+	}
+	return words
+}
+
+// commandOptionWords returns the options of cmd as completed after it,
+// with a trailing = for the options taking a value.
+func commandOptionWords(cmd *CommandDef) []string {
+	var words []string
+	for _, opt := range cmd.Options {
+		if opt.Kind == argBool {
+			words = append(words, "--"+opt.Name)
+		} else {
+			words = append(words, "--"+opt.Name+"=")
+		}
+	}
+	return words
+}
+
+// separateValueOptions returns the options followed by a value word.
+func separateValueOptions() []string {
+	var names []string
+	for _, opt := range Options {
+		if opt.Arg != nil && !opt.Joined {
+			names = append(names, opt.Names...)
+		}
+	}
+	return names
+}
+
+func bashValueAction(arg *ArgDef) string {
+	if isPathArg(arg) {
+		return `compopt -o filenames 2>/dev/null; COMPREPLY=($(compgen -f -- "$cur"))`
+	}
+	if values := argValues(arg); values != nil {
+		return fmt.Sprintf(`COMPREPLY=($(compgen -W "%s" -- "$cur"))`, strings.Join(values, " "))
+	}
+	return "COMPREPLY=()"
+}
+
+func bashCompletion() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# bash completion for %s, generated by \"%s completion bash\".\n\n", myApp, myApp)
+	b.WriteString("# _irony_server_value completes $2 as the value of the option $1 or as\n")
+	b.WriteString("# the argument \"COMMAND INDEX\" $1.\n")
+	b.WriteString("_irony_server_value() {\n")
+	b.WriteString("    local cur=$2\n")
+	b.WriteString("    case $1 in\n")
+	for _, opt := range Options {
+		if opt.Arg != nil {
+			fmt.Fprintf(&b, "        %s) %s ;;\n", strings.Join(opt.Names, "|"), bashValueAction(opt.Arg))
+		}
+	}
+	for _, cmd := range completionCommands() {
+		for i := range cmd.Args {
+			arg := &cmd.Args[i]
+			if isPathArg(arg) || argValues(arg) != nil {
+				fmt.Fprintf(&b, "        \"%s %d\") %s ;;\n", cmd.Name, i+1, bashValueAction(arg))
+			}
+		}
+		if len(cmd.Options) > 0 {
+			fmt.Fprintf(&b, "        \"%s --\") COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")) ;;\n",
+				cmd.Name, strings.Join(commandOptionWords(cmd), " "))
+		}
+	}
+	b.WriteString("        *) COMPREPLY=() ;;\n")
+	b.WriteString("    esac\n")
+	b.WriteString("}\n\n")
+
+	var names []string
+	for _, cmd := range completionCommands() {
+		names = append(names, cmd.Name)
+	}
+	fmt.Fprintf(&b, `_irony_server() {
+    local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+    if [[ $cur == --*=* ]]; then
+        _irony_server_value "${cur%%%%=*}" "${cur#*=}"
+        COMPREPLY=("${COMPREPLY[@]/#/${cur%%%%=*}=}")
+        return
+    fi
+    # = splits words if it is in COMP_WORDBREAKS.
+    if [[ $prev == = && $COMP_CWORD -ge 2 ]]; then
+        _irony_server_value "${COMP_WORDS[COMP_CWORD-2]}" "$cur"
+        return
+    fi
+    local i w cmd= n=0
+    for ((i = 1; i < COMP_CWORD; i++)); do
+        w=${COMP_WORDS[i]}
+        if [[ -z $cmd ]]; then
+            case $w in
+                %s)
+                    if ((i + 1 == COMP_CWORD)); then
+                        _irony_server_value "$w" "$cur"
+                        return
+                    fi
+                    ((i++)) ;;
+                =) ((i++)) ;;
+                -*) ;;
+                *) cmd=$w ;;
+            esac
+        elif [[ $w == -- ]]; then
+            # Compile options.
+            COMPREPLY=()
+            return
+        elif [[ $w == --* ]]; then
+            # Options of the command.
+            :
+        else
+            ((n++))
+        fi
+    done
+    if [[ -n $cmd && $cur == --* ]]; then
+        _irony_server_value "$cmd --" "$cur"
+        [[ ${COMPREPLY[0]} == *= ]] && compopt -o nospace 2>/dev/null
+    elif [[ -n $cmd ]]; then
+        _irony_server_value "$cmd $((n + 1))" "$cur"
+    elif [[ $cur == -* ]]; then
+        COMPREPLY=($(compgen -W "%s" -- "$cur"))
+        [[ ${COMPREPLY[0]} == *= ]] && compopt -o nospace 2>/dev/null
+    else
+        COMPREPLY=($(compgen -W "%s" -- "$cur"))
+    fi
+}
+
+complete -F _irony_server %s
+`, strings.Join(separateValueOptions(), "|"), strings.Join(optionWords(), " "),
+		strings.Join(names, " "), myApp)
+	return b.String()
+}
+
+// zshQuote quotes s for a single quoted zsh word.
+func zshQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// zshEscape escapes the characters special in an _arguments spec.
+func zshEscape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, ":", `\:`)
+	return r.Replace(s)
+}
+
+func zshAction(arg *ArgDef) string {
+	if isPathArg(arg) {
+		return "_files"
+	}
+	if values := argValues(arg); values != nil {
+		return "(" + strings.Join(values, " ") + ")"
+	}
+	return " "
+}
+
+func zshCompletion() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#compdef %s\n", myApp)
+	fmt.Fprintf(&b, "# zsh completion for %s, generated by \"%s completion zsh\".\n\n", myApp, myApp)
+	b.WriteString("_irony_server() {\n")
+	b.WriteString("    local curcontext=$curcontext state line ret=1\n")
+	b.WriteString("    local -a commands\n")
+	b.WriteString("    commands=(\n")
+	for _, cmd := range completionCommands() {
+		fmt.Fprintf(&b, "        %s\n", zshQuote(cmd.Name+":"+cmd.Desc))
+	}
+	b.WriteString("    )\n")
+	b.WriteString("    _arguments -C \\\n")
+	for _, opt := range Options {
+		for _, name := range opt.Names {
+			spec := name
+			if opt.Joined {
+				spec += "="
+			}
+			spec += "[" + zshEscape(opt.summary()) + "]"
+			if opt.Arg != nil {
+				spec += ":" + opt.Arg.Name + ":" + zshAction(opt.Arg)
+			}
+			fmt.Fprintf(&b, "        %s \\\n", zshQuote(spec))
+		}
+	}
+	b.WriteString("        '1: :->command' \\\n")
+	b.WriteString("        '*:: :->args' && ret=0\n")
+	b.WriteString("    case $state in\n")
+	b.WriteString("        command)\n")
+	b.WriteString("            _describe -t commands command commands && ret=0 ;;\n")
+	b.WriteString("        args)\n")
+	b.WriteString("            case $words[1] in\n")
+	for _, cmd := range completionCommands() {
+		if len(cmd.Args) == 0 && len(cmd.Options) == 0 && !cmd.CompileOptions {
+			continue
+		}
+		var specs []string
+		for i := range cmd.Options {
+			opt := &cmd.Options[i]
+			if opt.Kind == argBool {
+				specs = append(specs, zshQuote("--"+opt.Name))
+			} else {
+				specs = append(specs, zshQuote(fmt.Sprintf("--%s=:%s:%s", opt.Name, opt.Name, zshAction(opt))))
+			}
+		}
+		for i := range cmd.Args {
+			arg := &cmd.Args[i]
+			specs = append(specs, zshQuote(fmt.Sprintf("%d:%s:%s", i+1, arg.Name, zshAction(arg))))
+		}
+		if cmd.CompileOptions {
+			specs = append(specs, zshQuote("*:compile option: "))
+		}
+		fmt.Fprintf(&b, "                %s) _arguments %s && ret=0 ;;\n", cmd.Name, strings.Join(specs, " "))
+	}
+	b.WriteString("            esac ;;\n")
+	b.WriteString("    esac\n")
+	b.WriteString("    return ret\n")
+	b.WriteString("}\n\n")
+	b.WriteString("_irony_server \"$@\"\n")
+	return b.String()
+}
+
+// fishQuote quotes s for a single quoted fish word.
+func fishQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, "'", `\'`)
+	return "'" + r.Replace(s) + "'"
+}
+
+func fishValueFlags(arg *ArgDef) string {
+	if isPathArg(arg) {
+		return "-r -F"
+	}
+	if values := argValues(arg); values != nil {
+		return "-x -a " + fishQuote(strings.Join(values, " "))
+	}
+	return "-x"
+}
+
+func fishCompletion() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# fish completion for %s, generated by \"%s completion fish\".\n\n", myApp, myApp)
+	fmt.Fprintf(&b, `# __irony_server_state prints "COMMAND INDEX" of the argument being
+# completed, nothing before the command and "COMMAND --" in the compile
+# options.
+function __irony_server_state
+    set -l words (commandline -opc)
+    set -e words[1]
+    set -l cmd
+    set -l n 0
+    while set -q words[1]
+        set -l w $words[1]
+        set -e words[1]
+        if test -z "$cmd"
+            switch $w
+                case %s
+                    set -e words[1]
+                case '-*'
+                case '*'
+                    set cmd $w
+            end
+        else if test "$w" = --
+            echo "$cmd --"
+            return
+        else if string match -q -- '--*' $w
+            # Options of the command.
+        else
+            set n (math $n + 1)
+        end
+    end
+    if test -n "$cmd"
+        echo "$cmd" (math $n + 1)
+    end
+end
+
+function __irony_server_at
+    set -l state (__irony_server_state)
+    test "$state" = "$argv[1]"
+end
+
+complete -c %s -f
+`, strings.Join(separateValueOptions(), " "), myApp)
+	for _, opt := range Options {
+		var line string
+		for _, name := range opt.Names {
+			if strings.HasPrefix(name, "--") {
+				line += " -l " + strings.TrimPrefix(name, "--")
+			} else {
+				line += " -s " + strings.TrimPrefix(name, "-")
+			}
+		}
+		if opt.Arg != nil {
+			line += " " + fishValueFlags(opt.Arg)
+		}
+		fmt.Fprintf(&b, "complete -c %s -n \"__irony_server_at ''\"%s -d %s\n",
+			myApp, line, fishQuote(opt.summary()))
+	}
+	for _, cmd := range completionCommands() {
+		fmt.Fprintf(&b, "complete -c %s -n \"__irony_server_at ''\" -a %s -d %s\n",
+			myApp, cmd.Name, fishQuote(cmd.Desc))
+	}
+	for _, cmd := range completionCommands() {
+		for i := range cmd.Args {
+			arg := &cmd.Args[i]
+			cond := fishQuote(fmt.Sprintf("%s %d", cmd.Name, i+1))
+			if isPathArg(arg) {
+				fmt.Fprintf(&b, "complete -c %s -n \"__irony_server_at %s\" -F\n", myApp, cond)
+			} else if values := argValues(arg); values != nil {
+				fmt.Fprintf(&b, "complete -c %s -n \"__irony_server_at %s\" -a %s\n",
+					myApp, cond, fishQuote(strings.Join(values, " ")))
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/kangjianbin/irony-server/irony"
+	"github.com/kangjianbin/irony-server/logger"
+)
+
+// parseListenAddr splits ADDR into a network and an address. Paths and
+// "unix:PATH" select a unix socket, "tcp:HOST:PORT" or "HOST:PORT" TCP.
+func parseListenAddr(addr string) (string, string) {
+	if strings.HasPrefix(addr, "unix:") {
+		return "unix", strings.TrimPrefix(addr, "unix:")
+	}
+	if strings.HasPrefix(addr, "tcp:") {
+		return "tcp", strings.TrimPrefix(addr, "tcp:")
+	}
+	if strings.ContainsRune(addr, '/') {
+		return "unix", addr
+	}
+	return "tcp", addr
+}
+
+// removeStaleSocket removes a unix socket left behind by a previous
+// daemon. Anything else at path is left in place.
+func removeStaleSocket(path string) {
+	fi, err := os.Lstat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return
+	}
+	logger.Info("Removing stale socket %s\n", path)
+	os.Remove(path)
+}
+
+// runDaemon accepts clients on addr. Each connection runs the interactive
+// protocol with its own session, all sessions share one TUCache.
+func runDaemon(addr string, format string, async bool) error {
+	if newResponseEncoder(format, nil) == nil {
+		return fmt.Errorf("invalid format %s", format)
+	}
+	network, address := parseListenAddr(addr)
+	if network == "unix" {
+		removeStaleSocket(address)
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	if err := checkLoopback(listener); err != nil {
+		return err
+	}
+	atExit(func() { listener.Close() })
+	logger.Info("Listening on %s %s\n", network, address)
+
+	cache := irony.NewTuCache(serverConfigs.Global())
+	atExit(cache.Dispose)
+	for id := 1; ; id += 1 {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go serveSession(id, conn, cache, format, async)
+	}
+}
+
+func serveSession(id int, conn net.Conn, cache *irony.TUCache, format string, async bool) {
+	logger.Info("Session %d started\n", id)
+	if async {
+		runAsyncCommands(cache, format, conn, conn)
+		conn.Close()
+		logger.Info("Session %d closed\n", id)
+		return
+	}
+	s := newSharedSession(cache, newResponseEncoder(format, conn))
+	unregister := atExit(s.ir.Close)
+	defer func() {
+		cmdLock.RLock()
+		unregister()
+		s.ir.Close()
+		cmdLock.RUnlock()
+		conn.Close()
+		logger.Info("Session %d closed\n", id)
+	}()
+	runCommands(s, getCmdFromReaderFunc(conn, 0), conn)
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/kangjianbin/irony-server/irony"
+	"github.com/kangjianbin/irony-server/logger"
+)
+
+// responseEncoder turns the results of irony commands into the wire
+// format expected by the client.
+type responseEncoder interface {
+	Error(err error)
+	Success()
+	Nil()
+	Diagnostics(diags []irony.Diagnostic)
+	Candidates(cands []irony.Candidate)
+	CandidatePage(page *irony.CandidatePage)
+	CompletionContext(ctx *irony.CompletionContext)
+	Type(types []string)
+	CompileCommands(cmds []irony.CompileCommand)
+	Capabilities(caps *capabilitiesInfo)
+	Config(cfg *irony.Config)
+	Help(text string)
+}
+
+var responseFormats = map[string]func(io.Writer) responseEncoder{
+	"sexp": newSexpEncoder,
+	"json": newJSONEncoder,
+}
+
+func newResponseEncoder(format string, w io.Writer) responseEncoder {
+	if newEnc, ok := responseFormats[format]; ok {
+		return newEnc(w)
+	}
+	return nil
+}
+
+func formatNames() []string {
+	var names []string
+	for name := range responseFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func errorParts(err error) (string, string, []interface{}) {
+	switch e := err.(type) {
+	case *irony.Error:
+		return e.Kind, e.Msg, e.Args
+	case *commandError:
+		return e.kind, e.err, e.args
+	}
+	return "error", err.Error(), nil
+}
+
+type sexpEncoder struct {
+	w io.Writer
+}
+
+func newSexpEncoder(w io.Writer) responseEncoder {
+	return &sexpEncoder{w}
+}
+
+func (enc *sexpEncoder) write(format string, a ...interface{}) {
+	s := fmt.Sprintf(format, a...)
+	logger.Debug("%s", s)
+	fmt.Fprint(enc.w, s)
+}
+
+func sexpAtom(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return quote(v)
+	case bool:
+		if v {
+			return "t"
+		}
+		return "nil"
+	}
+	return fmt.Sprint(v)
+}
+
+func (enc *sexpEncoder) Error(err error) {
+	kind, msg, args := errorParts(err)
+	s := kind + " " + quote(msg)
+	for _, arg := range args {
+		s += " " + sexpAtom(arg)
+	}
+	s = "(error . (" + s + "))"
+	logger.Info("%s\n", s)
+	fmt.Fprintln(enc.w, s)
+}
+
+func (enc *sexpEncoder) Success() {
+	enc.write("(success . t)\n")
+}
+
+func (enc *sexpEncoder) Nil() {
+	enc.write("nil\n")
+}
+
+func (enc *sexpEncoder) Diagnostics(diags []irony.Diagnostic) {
+	enc.write("(\n")
+	for _, d := range diags {
+		enc.write("(%s %d %d %d %s %s)\n", quote(d.File), d.Line, d.Column, d.Offset,
+			d.Severity, quote(d.Message))
+	}
+	enc.write(")\n")
+}
+
+func (enc *sexpEncoder) Candidates(cands []irony.Candidate) {
+	enc.write("(\n")
+	enc.candidates(cands)
+	enc.write(")\n")
+}
+
+func (enc *sexpEncoder) CandidatePage(page *irony.CandidatePage) {
+	enc.write("((total . %d)\n (offset . %d)\n (candidates\n", page.Total, page.Offset)
+	enc.candidates(page.Candidates)
+	enc.write("))\n")
+}
+
+func (enc *sexpEncoder) candidates(cands []irony.Candidate) {
+	for _, c := range cands {
+		s := fmt.Sprintf(`  (%s %d %s %s %s %d (%s`,
+			quote(c.TypedText), c.Priority, quote(c.ResultType), quote(c.Brief),
+			quote(c.Prototype), c.AnnotationStart, quote(c.PostCompCar))
+		for _, v := range c.PostCompCdr {
+			s += fmt.Sprintf(" %d", v)
+		}
+		s += fmt.Sprintf(") %s %s", c.Availability, c.Kind)
+		if c.Doc != "" {
+			s += " " + quote(c.Doc)
+		}
+		s += ")\n"
+		enc.write("%s", s)
+	}
+}
+
+func (enc *sexpEncoder) CompletionContext(ctx *irony.CompletionContext) {
+	enc.write("((contexts . %s)\n (container-kind . %s)\n", sexpList(ctx.Contexts), quote(ctx.ContainerKind))
+	enc.write(" (container-usr . %s)\n (container-incomplete . %s))\n",
+		quote(ctx.ContainerUSR), sexpAtom(ctx.ContainerIncomplete))
+}
+
+func (enc *sexpEncoder) Type(types []string) {
+	s := "("
+	for _, t := range types {
+		s += quote(t) + " "
+	}
+	s += ")"
+	enc.write("%s", s)
+}
+
+func (enc *sexpEncoder) CompileCommands(cmds []irony.CompileCommand) {
+	var s []string
+	for _, cc := range cmds {
+		var args []string
+		for _, arg := range cc.Args {
+			args = append(args, quote(arg))
+		}
+		s = append(s, "(("+strings.Join(args, " ")+") . "+quote(cc.Directory)+")")
+	}
+	enc.write("(success . (\n%s\n))\n", strings.Join(s, "\n"))
+}
+
+func sexpList(values []string) string {
+	var s []string
+	for _, v := range values {
+		s = append(s, quote(v))
+	}
+	return "(" + strings.Join(s, " ") + ")"
+}
+
+func (enc *sexpEncoder) Capabilities(caps *capabilitiesInfo) {
+	enc.write("((protocol-version . %d)\n (version . %s)\n (clang-version . %s)\n",
+		caps.ProtocolVersion, quote(caps.Version), quote(caps.ClangVersion))
+	enc.write(" (commands . %s)\n (matching-styles . %s)\n (formats . %s)\n (modes . %s))\n",
+		sexpList(caps.Commands), sexpList(caps.MatchingStyles), sexpList(caps.Formats),
+		sexpList(caps.Modes))
+}
+
+func (enc *sexpEncoder) Config(cfg *irony.Config) {
+	enc.write("((max-candidates . %d)\n (matching-style . %s)\n (parse-options . %s)\n",
+		cfg.MaxCandidates, quote(cfg.MatchingStyle), sexpList(cfg.ParseOptions))
+	enc.write(" (clang-header-dir . %s)\n (log-file . %s)\n (debug . %s)\n (files . %s))\n",
+		quote(cfg.ClangHeaderDir), quote(cfg.LogFile), sexpAtom(cfg.Debug), sexpList(cfg.Files))
+}
+
+func (enc *sexpEncoder) Help(text string) {
+	enc.write("%s", quote(text))
+}
+
+// jsonEncoder writes one JSON document per response.
+type jsonEncoder struct {
+	w io.Writer
+}
+
+func newJSONEncoder(w io.Writer) responseEncoder {
+	return &jsonEncoder{w}
+}
+
+func (enc *jsonEncoder) write(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		logger.Info("Failed to encode response: %s\n", err)
+		data = []byte("null")
+	}
+	logger.Debug("%s\n", data)
+	fmt.Fprintf(enc.w, "%s\n", data)
+}
+
+type jsonError struct {
+	Kind    string        `json:"kind"`
+	Message string        `json:"message"`
+	Args    []interface{} `json:"args"`
+}
+
+func newJSONError(err error) *jsonError {
+	kind, msg, args := errorParts(err)
+	if args == nil {
+		args = []interface{}{}
+	}
+	return &jsonError{kind, msg, args}
+}
+
+func (enc *jsonEncoder) Error(err error) {
+	e := newJSONError(err)
+	logger.Info("%s: %s %v\n", e.Kind, e.Message, e.Args)
+	enc.write(map[string]interface{}{"error": e})
+}
+
+func (enc *jsonEncoder) Success() {
+	enc.write(map[string]bool{"success": true})
+}
+
+func (enc *jsonEncoder) Nil() {
+	enc.write(nil)
+}
+
+func (enc *jsonEncoder) Diagnostics(diags []irony.Diagnostic) {
+	if diags == nil {
+		diags = []irony.Diagnostic{}
+	}
+	enc.write(diags)
+}
+
+func (enc *jsonEncoder) Candidates(cands []irony.Candidate) {
+	if cands == nil {
+		cands = []irony.Candidate{}
+	}
+	enc.write(cands)
+}
+
+func (enc *jsonEncoder) CandidatePage(page *irony.CandidatePage) {
+	if page.Candidates == nil {
+		page.Candidates = []irony.Candidate{}
+	}
+	enc.write(page)
+}
+
+func (enc *jsonEncoder) CompletionContext(ctx *irony.CompletionContext) {
+	enc.write(ctx)
+}
+
+func (enc *jsonEncoder) Type(types []string) {
+	if types == nil {
+		types = []string{}
+	}
+	enc.write(types)
+}
+
+func (enc *jsonEncoder) CompileCommands(cmds []irony.CompileCommand) {
+	if cmds == nil {
+		cmds = []irony.CompileCommand{}
+	}
+	enc.write(map[string]interface{}{"success": cmds})
+}
+
+func (enc *jsonEncoder) Capabilities(caps *capabilitiesInfo) {
+	enc.write(caps)
+}
+
+func (enc *jsonEncoder) Config(cfg *irony.Config) {
+	enc.write(cfg)
+}
+
+func (enc *jsonEncoder) Help(text string) {
+	enc.write(text)
+}
+
+// valueEncoder keeps the response as a Go value instead of writing it,
+// for transports that frame responses themselves.
+type valueEncoder struct {
+	result interface{}
+	err    error
+}
+
+func (enc *valueEncoder) reset() {
+	enc.result = nil
+	enc.err = nil
+}
+
+func (enc *valueEncoder) Error(err error) {
+	logger.Info("%s\n", err)
+	enc.err = err
+}
+
+func (enc *valueEncoder) Success() {
+	enc.result = true
+}
+
+func (enc *valueEncoder) Nil() {
+	enc.result = nil
+}
+
+func (enc *valueEncoder) Diagnostics(diags []irony.Diagnostic) {
+	if diags == nil {
+		diags = []irony.Diagnostic{}
+	}
+	enc.result = diags
+}
+
+func (enc *valueEncoder) Candidates(cands []irony.Candidate) {
+	if cands == nil {
+		cands = []irony.Candidate{}
+	}
+	enc.result = cands
+}
+
+func (enc *valueEncoder) CandidatePage(page *irony.CandidatePage) {
+	if page.Candidates == nil {
+		page.Candidates = []irony.Candidate{}
+	}
+	enc.result = page
+}
+
+func (enc *valueEncoder) CompletionContext(ctx *irony.CompletionContext) {
+	enc.result = ctx
+}
+
+func (enc *valueEncoder) Type(types []string) {
+	if types == nil {
+		types = []string{}
+	}
+	enc.result = types
+}
+
+func (enc *valueEncoder) CompileCommands(cmds []irony.CompileCommand) {
+	if cmds == nil {
+		cmds = []irony.CompileCommand{}
+	}
+	enc.result = cmds
+}
+
+func (enc *valueEncoder) Capabilities(caps *capabilitiesInfo) {
+	enc.result = caps
+}
+
+func (enc *valueEncoder) Config(cfg *irony.Config) {
+	enc.result = cfg
+}
+
+func (enc *valueEncoder) Help(text string) {
+	enc.result = text
+}
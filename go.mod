@@ -0,0 +1,3 @@
+module github.com/kangjianbin/irony-server
+
+go 1.17
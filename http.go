@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/kangjianbin/irony-server/irony"
+	"github.com/kangjianbin/irony-server/logger"
+)
+
+// HTTP front end for scripted tooling. Every command of the registry is
+// served as POST /NAME, the body holds the params as accepted by the
+// JSON-RPC mode. Responses are {"result": ...} or {"error": ...} with the
+// JSON-RPC error object. GET / lists the commands like help.
+//
+// The Irony-Session header names the session of a request, whatever the
+// connection it comes on. A named session is created by its first request
+// and keeps its state, e.g. unsaved buffers and the last complete, until
+// DELETE /session ends it. A request without the header runs on a session
+// of its own, dropped with the request. A command reading the state of a
+// previous parse or complete fails if its session has none. All sessions
+// share one TUCache.
+//
+// A TCP server only listens on loopback addresses, unless --allow-remote
+// is given, and only answers requests whose Host and Origin name the
+// loopback, so that web pages cannot reach it through DNS rebinding.
+
+type httpServer struct {
+	cache  *irony.TUCache
+	server *http.Server
+	// checkHost rejects the requests not addressed to the loopback.
+	checkHost bool
+
+	// mu guards sessions.
+	mu       sync.Mutex
+	sessions map[string]*httpSession
+}
+
+// httpSession is a session of the HTTP front end.
+type httpSession struct {
+	// mu serializes the commands on sess.
+	mu         sync.Mutex
+	sess       *session
+	enc        *valueEncoder
+	unregister func()
+}
+
+// httpSessionHeader names the session of a request.
+const httpSessionHeader = "Irony-Session"
+
+// allowRemote lets --http and --listen serve non-loopback addresses.
+var allowRemote bool
+
+type httpResponse struct {
+	Result interface{} `json:"result"`
+}
+
+type httpErrorResponse struct {
+	Error *rpcError `json:"error"`
+}
+
+func httpStatus(code int) int {
+	switch code {
+	case rpcMethodNotFound:
+		return http.StatusNotFound
+	case rpcParseError, rpcInvalidParams:
+		return http.StatusBadRequest
+	case rpcServerError:
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+func writeHTTP(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		logger.Info("Failed to encode response: %s\n", err)
+		status = http.StatusInternalServerError
+		data, _ = json.Marshal(&httpErrorResponse{&rpcError{rpcInternalError, err.Error(), nil}})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+	w.Write([]byte("\n"))
+}
+
+func writeHTTPError(w http.ResponseWriter, rerr *rpcError) {
+	writeHTTP(w, httpStatus(rerr.Code), &httpErrorResponse{rerr})
+}
+
+// isLoopbackHost reports whether host, a host name with an optional port,
+// names the loopback.
+func isLoopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// isLocalRequest reports whether req is addressed to the loopback, and
+// comes from a local page if it comes from a browser.
+func isLocalRequest(req *http.Request) bool {
+	if !isLoopbackHost(req.Host) {
+		return false
+	}
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && isLoopbackHost(u.Host)
+}
+
+func (s *httpServer) newSession() *httpSession {
+	enc := &valueEncoder{}
+	hs := &httpSession{sess: newSharedSession(s.cache, enc), enc: enc}
+	hs.unregister = atExit(hs.sess.ir.Close)
+	return hs
+}
+
+// session returns the session called name, created if needed.
+func (s *httpServer) session(name string) *httpSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hs, ok := s.sessions[name]
+	if !ok {
+		logger.Debug("New HTTP session %s\n", name)
+		hs = s.newSession()
+		s.sessions[name] = hs
+	}
+	return hs
+}
+
+// endSession closes the session called name, it reports whether there
+// was one.
+func (s *httpServer) endSession(name string) bool {
+	s.mu.Lock()
+	hs, ok := s.sessions[name]
+	delete(s.sessions, name)
+	s.mu.Unlock()
+	if ok {
+		hs.close()
+	}
+	return ok
+}
+
+func (hs *httpSession) close() {
+	hs.mu.Lock()
+	cmdLock.RLock()
+	hs.unregister()
+	hs.sess.ir.Close()
+	cmdLock.RUnlock()
+	hs.mu.Unlock()
+}
+
+// missingState returns the error of a command run without the state it
+// reads, nil if the state is there.
+func missingState(sess *session, cmd *CommandDef) *rpcError {
+	switch {
+	case cmd.Requires == "parse" && !sess.ir.HasTU():
+	case cmd.Requires == "complete" && !sess.ir.HasCompletion():
+	default:
+		return nil
+	}
+	err := irony.NewError("no-"+cmd.Requires, "no previous "+cmd.Requires+" in this session", cmd.Name)
+	return &rpcError{rpcServerError, err.Msg, newJSONError(err)}
+}
+
+func (s *httpServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	method := strings.TrimPrefix(req.URL.Path, "/")
+	logger.Debug("HTTP %s %s\n", req.Method, req.URL.Path)
+	if s.checkHost && !isLocalRequest(req) {
+		logger.Info("Rejected HTTP request for host %s, origin %s\n", req.Host, req.Header.Get("Origin"))
+		writeHTTP(w, http.StatusForbidden,
+			&httpErrorResponse{&rpcError{rpcInvalidRequest, "request not addressed to the loopback", nil}})
+		return
+	}
+	if req.Method == http.MethodGet && (method == "" || method == "help") {
+		writeHTTP(w, http.StatusOK, &httpResponse{rpcHelp()})
+		return
+	}
+	name := req.Header.Get(httpSessionHeader)
+	if req.Method == http.MethodDelete && method == "session" {
+		if name == "" || !s.endSession(name) {
+			writeHTTP(w, http.StatusNotFound,
+				&httpErrorResponse{&rpcError{rpcInvalidRequest, "no session: " + name, nil}})
+			return
+		}
+		writeHTTP(w, http.StatusOK, &httpResponse{true})
+		return
+	}
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeHTTP(w, http.StatusMethodNotAllowed,
+			&httpErrorResponse{&rpcError{rpcInvalidRequest, "method not allowed: " + req.Method, nil}})
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxPayload))
+	if err != nil {
+		writeHTTPError(w, &rpcError{rpcParseError, err.Error(), nil})
+		return
+	}
+	var params json.RawMessage
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if !json.Valid(body) {
+			writeHTTPError(w, &rpcError{rpcParseError, "invalid JSON body", nil})
+			return
+		}
+		params = body
+	}
+
+	var hs *httpSession
+	if name != "" {
+		hs = s.session(name)
+	} else {
+		hs = s.newSession()
+		defer hs.close()
+	}
+	hs.mu.Lock()
+	cmdLock.RLock()
+	var result interface{}
+	var rerr *rpcError
+	if cmd, ok := commandMap()[method]; ok {
+		rerr = missingState(hs.sess, cmd)
+	}
+	if rerr == nil {
+		result, rerr = callCommand(hs.sess, hs.enc, method, params)
+	}
+	cmdLock.RUnlock()
+	hs.mu.Unlock()
+	if rerr != nil {
+		writeHTTPError(w, rerr)
+		return
+	}
+	writeHTTP(w, http.StatusOK, &httpResponse{result})
+	if method == "exit" {
+		go s.server.Close()
+	}
+}
+
+// checkLoopback fails for a TCP listener not bound to the loopback,
+// unless --allow-remote was given.
+func checkLoopback(listener net.Listener) error {
+	addr, ok := listener.Addr().(*net.TCPAddr)
+	if !ok || allowRemote || addr.IP.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("%s is not a loopback address, use --allow-remote to serve it", addr)
+}
+
+// runHTTP serves the commands over HTTP on addr until the exit command.
+// addr is parsed like --listen.
+func runHTTP(addr string) error {
+	network, address := parseListenAddr(addr)
+	if network == "unix" {
+		removeStaleSocket(address)
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	if err := checkLoopback(listener); err != nil {
+		listener.Close()
+		return err
+	}
+	logger.Info("Serving HTTP on %s %s\n", network, address)
+
+	cache := irony.NewTuCache(serverConfigs.Global())
+	atExit(cache.Dispose)
+	s := &httpServer{cache: cache, checkHost: network == "tcp" && !allowRemote,
+		sessions: make(map[string]*httpSession)}
+	s.server = &http.Server{Handler: s}
+	atExit(func() { listener.Close() })
+	err = s.server.Serve(listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,323 @@
+package irony
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kangjianbin/irony-server/clang"
+	"github.com/kangjianbin/irony-server/logger"
+)
+
+// Server defaults are read from $XDG_CONFIG_HOME/irony-server/config and
+// from the nearest .irony-server file above a parsed file. Both contain
+// "key = value" lines, # starts a comment. Overrides, the command line
+// flags of the server, take precedence over both files.
+
+const (
+	configDirName     = "irony-server"
+	configFileName    = "config"
+	projectConfigName = ".irony-server"
+)
+
+type Config struct {
+	// MaxCandidates is the default limit of candidates, 0 for no limit.
+	// It is 0 unless set, clients filtering the list themselves need all
+	// the candidates.
+	MaxCandidates  int      `json:"max_candidates"`
+	MatchingStyle  string   `json:"matching_style"`
+	ParseOptions   []string `json:"parse_options"`
+	ClangHeaderDir string   `json:"clang_header_dir"`
+	LogFile        string   `json:"log_file"`
+	Debug          bool     `json:"debug"`
+	// Config files the values were read from.
+	Files []string `json:"files"`
+}
+
+type configKey struct {
+	name string
+	// Project keys may be set by a project .irony-server.
+	project bool
+	set     func(cfg *Config, value string) error
+}
+
+// parseOptionFlags are the translation unit options known to
+// parse-options, on top of the default editing options of libclang.
+var parseOptionFlags = map[string]uint32{
+	"detailed-preprocessing-record":  uint32(clang.TranslationUnit_DetailedPreprocessingRecord),
+	"incomplete":                     uint32(clang.TranslationUnit_Incomplete),
+	"precompiled-preamble":           uint32(clang.TranslationUnit_PrecompiledPreamble),
+	"cache-completion-results":       uint32(clang.TranslationUnit_CacheCompletionResults),
+	"skip-function-bodies":           uint32(clang.TranslationUnit_SkipFunctionBodies),
+	"include-brief-comments":         uint32(clang.TranslationUnit_IncludeBriefCommentsInCodeCompletion),
+	"create-preamble-on-first-parse": uint32(clang.TranslationUnit_CreatePreambleOnFirstParse),
+	"keep-going":                     uint32(clang.TranslationUnit_KeepGoing),
+}
+
+var configKeys = []configKey{
+	{"max-candidates", true,
+		func(cfg *Config, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid number %s", value)
+			}
+			cfg.MaxCandidates = n
+			return nil
+		}},
+	{"matching-style", true,
+		func(cfg *Config, value string) error {
+			if _, ok := MatchingStyle(value); !ok {
+				return fmt.Errorf("matching style must be one of %s", strings.Join(MatchingStyles, ", "))
+			}
+			cfg.MatchingStyle = value
+			return nil
+		}},
+	{"parse-options", false,
+		func(cfg *Config, value string) error {
+			var opts []string
+			for _, opt := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+				if _, ok := parseOptionFlags[opt]; !ok {
+					return fmt.Errorf("unknown parse option %s", opt)
+				}
+				opts = append(opts, opt)
+			}
+			cfg.ParseOptions = opts
+			return nil
+		}},
+	{"clang-header-dir", true,
+		func(cfg *Config, value string) error {
+			cfg.ClangHeaderDir = value
+			return nil
+		}},
+	{"log-file", false,
+		func(cfg *Config, value string) error {
+			cfg.LogFile = value
+			return nil
+		}},
+	{"debug", false,
+		func(cfg *Config, value string) error {
+			if value != "on" && value != "off" {
+				return fmt.Errorf("debug must be on or off")
+			}
+			cfg.Debug = value == "on"
+			return nil
+		}},
+}
+
+func findConfigKey(name string) *configKey {
+	for i := range configKeys {
+		if configKeys[i].name == name {
+			return &configKeys[i]
+		}
+	}
+	return nil
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		MatchingStyle: "exact",
+		ParseOptions: []string{"detailed-preprocessing-record", "incomplete",
+			"create-preamble-on-first-parse", "keep-going", "include-brief-comments"},
+	}
+}
+
+func (cfg *Config) clone() *Config {
+	c := *cfg
+	c.Files = append([]string{}, cfg.Files...)
+	return &c
+}
+
+// ParseOptionBits returns the translation unit options for NewTuCache.
+func (cfg *Config) ParseOptionBits() uint32 {
+	bits := clang.DefaultEditingTranslationUnitOptions()
+	for _, opt := range cfg.ParseOptions {
+		bits |= parseOptionFlags[opt]
+	}
+	return bits
+}
+
+// CompileFlags adds the builtin headers to the flags of a parse.
+func (cfg *Config) CompileFlags(flags []string) []string {
+	if cfg.ClangHeaderDir == "" {
+		return flags
+	}
+	return append(append([]string{}, flags...), "-isystem", cfg.ClangHeaderDir)
+}
+
+// readConfigFile applies the settings of the config file name to cfg.
+// Keys not allowed in a project file are ignored.
+func readConfigFile(cfg *Config, name string, project bool) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo += 1 {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return fmt.Errorf("%s:%d: expected key = value", name, lineNo)
+		}
+		key := strings.TrimSpace(line[:eq])
+		value := strings.TrimSpace(line[eq+1:])
+		ck := findConfigKey(key)
+		if ck == nil {
+			return fmt.Errorf("%s:%d: unknown key %s", name, lineNo, key)
+		}
+		if project && !ck.project {
+			logger.Info("%s:%d: %s is ignored in a project config\n", name, lineNo, key)
+			continue
+		}
+		if err := ck.set(cfg, value); err != nil {
+			return fmt.Errorf("%s:%d: %s", name, lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	cfg.Files = append(cfg.Files, name)
+	return nil
+}
+
+func userConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, configDirName, configFileName)
+}
+
+// findProjectConfig returns the nearest project config above file and
+// its modification time.
+func findProjectConfig(file string) (string, time.Time) {
+	dir, err := filepath.Abs(filepath.Dir(file))
+	if err != nil {
+		return "", time.Time{}
+	}
+	for {
+		name := filepath.Join(dir, projectConfigName)
+		if fi, err := os.Stat(name); err == nil && !fi.IsDir() {
+			return name, fi.ModTime()
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", time.Time{}
+		}
+		dir = parent
+	}
+}
+
+// projectConfig is the configuration read from a project config file,
+// nil if the file is invalid.
+type projectConfig struct {
+	modTime time.Time
+	cfg     *Config
+}
+
+// ConfigLoader combines the defaults, the user config file, the project
+// config files and the overrides into the configuration of a file.
+type ConfigLoader struct {
+	// base holds the defaults and the user config file.
+	base      *Config
+	overrides map[string]string
+	// global is the effective configuration without project file.
+	global *Config
+
+	mu sync.Mutex
+	// projects caches the project config files by path, a file is read
+	// again when its modification time changes.
+	projects map[string]projectConfig
+}
+
+func NewConfigLoader(defaults *Config) *ConfigLoader {
+	return &ConfigLoader{
+		base:      defaults.clone(),
+		overrides: map[string]string{},
+		global:    defaults.clone(),
+		projects:  map[string]projectConfig{},
+	}
+}
+
+func (l *ConfigLoader) applyOverrides(cfg *Config) {
+	for key, value := range l.overrides {
+		findConfigKey(key).set(cfg, value)
+	}
+}
+
+// Override sets key to value whatever the config files say.
+func (l *ConfigLoader) Override(key string, value string) error {
+	ck := findConfigKey(key)
+	if ck == nil {
+		return fmt.Errorf("unknown key %s", key)
+	}
+	if err := ck.set(l.global, value); err != nil {
+		return err
+	}
+	l.overrides[key] = value
+	l.forgetProjects()
+	return nil
+}
+
+func (l *ConfigLoader) forgetProjects() {
+	l.mu.Lock()
+	l.projects = map[string]projectConfig{}
+	l.mu.Unlock()
+}
+
+// LoadUserConfig reads the user config file, if any.
+func (l *ConfigLoader) LoadUserConfig() error {
+	if name := userConfigPath(); name != "" {
+		err := readConfigFile(l.base, name, false)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	l.global = l.base.clone()
+	l.applyOverrides(l.global)
+	l.forgetProjects()
+	return nil
+}
+
+// Global returns the configuration without project file.
+func (l *ConfigLoader) Global() *Config {
+	return l.global
+}
+
+// For returns the effective configuration for file. The returned
+// configuration is shared and must not be modified.
+func (l *ConfigLoader) For(file string) *Config {
+	name, modTime := findProjectConfig(file)
+	if name == "" {
+		return l.global
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	pc, ok := l.projects[name]
+	if !ok || !pc.modTime.Equal(modTime) {
+		pc = projectConfig{modTime, l.base.clone()}
+		if err := readConfigFile(pc.cfg, name, true); err != nil {
+			logger.Info("Config: %s\n", err)
+			pc.cfg = nil
+		} else {
+			l.applyOverrides(pc.cfg)
+		}
+		l.projects[name] = pc
+	}
+	if pc.cfg == nil {
+		return l.global
+	}
+	return pc.cfg
+}
@@ -0,0 +1,86 @@
+package irony
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadConfigFile(t *testing.T) {
+	tests := []struct {
+		content string
+		project bool
+		// want edits the default config into the expected one, nil if
+		// reading fails.
+		want func(cfg *Config)
+	}{
+		{"", false, func(cfg *Config) {}},
+		{"# comment\n\n  max-candidates = 30  \n", false, func(cfg *Config) { cfg.MaxCandidates = 30 }},
+		{"matching-style=fuzzy\ndebug = on\n", false, func(cfg *Config) {
+			cfg.MatchingStyle = "fuzzy"
+			cfg.Debug = true
+		}},
+		{"parse-options = incomplete, keep-going\n", false, func(cfg *Config) {
+			cfg.ParseOptions = []string{"incomplete", "keep-going"}
+		}},
+		{"clang-header-dir = /usr/lib/clang/include\nlog-file = /tmp/a b.log\n", false, func(cfg *Config) {
+			cfg.ClangHeaderDir = "/usr/lib/clang/include"
+			cfg.LogFile = "/tmp/a b.log"
+		}},
+		// A project file cannot set the keys of the server.
+		{"max-candidates = 5\ndebug = on\nlog-file = x\nparse-options = incomplete\n", true,
+			func(cfg *Config) { cfg.MaxCandidates = 5 }},
+		{"max-candidates = -1\n", false, nil},
+		{"max-candidates = many\n", false, nil},
+		{"matching-style = loose\n", false, nil},
+		{"parse-options = bogus\n", false, nil},
+		{"debug = yes\n", false, nil},
+		{"bogus = 1\n", false, nil},
+		{"max-candidates\n", false, nil},
+	}
+	name := filepath.Join(t.TempDir(), "config")
+	for _, test := range tests {
+		if err := ioutil.WriteFile(name, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg := DefaultConfig()
+		err := readConfigFile(cfg, name, test.project)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("readConfigFile(%q) succeeded, want an error", test.content)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("readConfigFile(%q) failed: %s", test.content, err)
+			continue
+		}
+		want := DefaultConfig()
+		test.want(want)
+		want.Files = []string{name}
+		if !reflect.DeepEqual(cfg, want) {
+			t.Errorf("readConfigFile(%q) = %+v, want %+v", test.content, cfg, want)
+		}
+	}
+}
+
+func TestConfigLoaderFor(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "src", "sub")
+	if err := ioutil.WriteFile(filepath.Join(root, projectConfigName),
+		[]byte("max-candidates = 7\nmatching-style = fuzzy\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l := NewConfigLoader(DefaultConfig())
+	if err := l.Override("matching-style", "smart-case"); err != nil {
+		t.Fatal(err)
+	}
+	cfg := l.For(filepath.Join(sub, "a.c"))
+	if cfg.MaxCandidates != 7 || cfg.MatchingStyle != "smart-case" {
+		t.Errorf("For() = %+v, want max-candidates 7 from the project and the overridden style", cfg)
+	}
+	if l.For(filepath.Join(root, "b.c")) != cfg {
+		t.Errorf("For() read the project config again")
+	}
+}
@@ -0,0 +1,112 @@
+package irony
+
+import (
+	"strings"
+
+	"github.com/kangjianbin/irony-server/clang"
+)
+
+// commentMarkers are stripped from the start of the lines of a doc
+// comment, longest first.
+var commentMarkers = []string{"///<", "//!<", "/**<", "/*!<", "///", "//!", "/**", "/*!", "//", "/*"}
+
+// cleanComment strips the comment markers of a raw comment and the blank
+// lines around it, the indentation of the text is kept.
+func cleanComment(raw string) string {
+	var lines []string
+	for _, line := range strings.Split(raw, "\n") {
+		line = strings.TrimSpace(line)
+		for _, marker := range commentMarkers {
+			if strings.HasPrefix(line, marker) {
+				line = line[len(marker):]
+				break
+			}
+		}
+		line = strings.TrimSuffix(line, "*/")
+		if strings.HasPrefix(line, "*") {
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(strings.TrimRight(line, " \t"), " ")
+		lines = append(lines, line)
+	}
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// qualifiedName returns the name of the declaration of cursor qualified
+// by its semantic parents, e.g. ns::Class::name, anonymous parents are
+// skipped.
+func qualifiedName(cursor clang.Cursor) string {
+	name := cursor.Spelling()
+	for p := cursor.SemanticParent(); !p.IsNull() && p.Kind().IsDeclaration(); p = p.SemanticParent() {
+		if s := p.Spelling(); s != "" {
+			name = s + "::" + name
+		}
+	}
+	return name
+}
+
+// isFunctionDecl reports whether kind declares a function, whose body
+// holds no declaration a completion can name.
+func isFunctionDecl(kind clang.CursorKind) bool {
+	switch kind {
+	case clang.Cursor_FunctionDecl, clang.Cursor_CXXMethod, clang.Cursor_Constructor,
+		clang.Cursor_Destructor, clang.Cursor_ConversionFunction, clang.Cursor_FunctionTemplate,
+		clang.Cursor_ObjCInstanceMethodDecl, clang.Cursor_ObjCClassMethodDecl:
+		return true
+	}
+	return false
+}
+
+// buildDocs indexes the doc comments of the declarations of tu by their
+// qualified name and by each of its suffixes, e.g. ns::Class::name,
+// Class::name and name, so that a completion can look them up with the
+// parent it knows. The first declaration of a key wins. Function bodies
+// are not visited. It must run on the worker of the TU.
+func buildDocs(tu clang.TranslationUnit) map[string]string {
+	docs := make(map[string]string)
+	tu.TranslationUnitCursor().Visit(func(cursor, parent clang.Cursor) clang.ChildVisitResult {
+		kind := cursor.Kind()
+		if !kind.IsDeclaration() {
+			return clang.ChildVisit_Continue
+		}
+		if raw := cursor.RawCommentText(); raw != "" {
+			doc := cleanComment(raw)
+			key := qualifiedName(cursor)
+			for {
+				if _, ok := docs[key]; !ok {
+					docs[key] = doc
+				}
+				i := strings.Index(key, "::")
+				if i < 0 {
+					break
+				}
+				key = key[i+2:]
+			}
+		}
+		if isFunctionDecl(kind) {
+			return clang.ChildVisit_Continue
+		}
+		return clang.ChildVisit_Recurse
+	})
+	return docs
+}
+
+// docFor returns the doc comment of the declaration completed by res.
+// It must run on the worker of td.
+func (td *TUData) docFor(res clang.CompletionResult, name string) string {
+	if td.docs == nil {
+		td.docs = buildDocs(td.tu)
+	}
+	if parent := res.CompletionString().Parent(); parent != "" {
+		if doc, ok := td.docs[parent+"::"+name]; ok {
+			return doc
+		}
+	}
+	return td.docs[name]
+}
@@ -0,0 +1,27 @@
+package irony
+
+import "testing"
+
+func TestCleanComment(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"", ""},
+		{"/// Returns the value.", "Returns the value."},
+		{"//! Returns the value.", "Returns the value."},
+		{"// plain", "plain"},
+		{"/* a */", "a"},
+		{"int x; //!< Trailing member doc.", "int x; //!< Trailing member doc."},
+		{"//!< Trailing member doc.", "Trailing member doc."},
+		{"/**\n * First line.\n *\n * Second.\n */", "First line.\n\nSecond."},
+		{"/*!\n  Qt style.\n*/", "Qt style."},
+		{"/// code:\n///     x = 1", "code:\n    x = 1"},
+		{"///\n/// Padded.\n///", "Padded."},
+	}
+	for _, test := range tests {
+		if got := cleanComment(test.raw); got != test.want {
+			t.Errorf("cleanComment(%q) = %q, want %q", test.raw, got, test.want)
+		}
+	}
+}
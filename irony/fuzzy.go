@@ -0,0 +1,149 @@
+package irony
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Scores of the fuzzy matching style. A pattern matches a candidate if
+// its characters appear in order in the candidate, ignoring case.
+// Characters matched at the start of a word, after an underscore or on a
+// camelCase hump score more, as do runs of contiguous characters. Gaps
+// between the matched characters cost, the unmatched characters before
+// the first and after the last one cost up to fuzzyMaxEdgePen.
+const (
+	fuzzyMatch       = 1
+	fuzzyStart       = 10
+	fuzzyBoundary    = 8
+	fuzzyHump        = 7
+	fuzzyContiguous  = 5
+	fuzzyCase        = 1
+	fuzzyGap         = -2
+	fuzzyEdgeGap     = -1
+	fuzzyMaxEdgePen  = -3
+	fuzzyNoMatch     = -1 << 30
+	fuzzyPriorityDiv = 5
+)
+
+// fuzzyBonus returns the score of matching pattern rune p at position j
+// of text.
+func fuzzyBonus(text []rune, j int, p rune) int {
+	score := fuzzyMatch
+	c := text[j]
+	if c == p {
+		score += fuzzyCase
+	}
+	if j == 0 {
+		return score + fuzzyStart
+	}
+	prev := text[j-1]
+	switch {
+	case !unicode.IsLetter(prev) && !unicode.IsDigit(prev):
+		score += fuzzyBoundary
+	case unicode.IsUpper(c) && unicode.IsLower(prev):
+		score += fuzzyHump
+	case unicode.IsDigit(c) && !unicode.IsDigit(prev):
+		score += fuzzyHump
+	}
+	return score
+}
+
+// edgePenalty returns the cost of n unmatched characters at an end of
+// the candidate.
+func edgePenalty(n int) int {
+	pen := n * fuzzyEdgeGap
+	if pen < fuzzyMaxEdgePen {
+		pen = fuzzyMaxEdgePen
+	}
+	return pen
+}
+
+// fuzzyScore returns the best score of pattern as a subsequence of text,
+// and false if it is not one.
+func fuzzyScore(pattern string, text string) (int, bool) {
+	if pattern == "" {
+		return 0, true
+	}
+	if utf8.RuneCountInString(pattern) > utf8.RuneCountInString(text) {
+		return 0, false
+	}
+	pat := []rune(pattern)
+	txt := []rune(text)
+	lower := make([]rune, len(txt))
+	for j, c := range txt {
+		lower[j] = unicode.ToLower(c)
+	}
+
+	// best[j] is the best score of the pattern so far with its last rune
+	// matched at txt[j].
+	best := make([]int, len(txt))
+	next := make([]int, len(txt))
+	for i, p := range pat {
+		lp := unicode.ToLower(p)
+		for j := range txt {
+			next[j] = fuzzyNoMatch
+			if lower[j] != lp {
+				continue
+			}
+			bonus := fuzzyBonus(txt, j, p)
+			if i == 0 {
+				next[j] = bonus + edgePenalty(j)
+				continue
+			}
+			for k := i - 1; k < j; k += 1 {
+				if best[k] == fuzzyNoMatch {
+					continue
+				}
+				s := best[k] + bonus
+				if k == j-1 {
+					s += fuzzyContiguous
+				} else {
+					s += (j - k - 1) * fuzzyGap
+				}
+				if s > next[j] {
+					next[j] = s
+				}
+			}
+		}
+		best, next = next, best
+	}
+
+	score := fuzzyNoMatch
+	for j, s := range best {
+		if s == fuzzyNoMatch {
+			continue
+		}
+		if s += edgePenalty(len(txt) - 1 - j); s > score {
+			score = s
+		}
+	}
+	if score == fuzzyNoMatch {
+		return 0, false
+	}
+	return score, true
+}
+
+// rankedCandidates sorts candidates by decreasing rank.
+type rankedCandidates struct {
+	cands []Candidate
+	ranks []int
+}
+
+func (r *rankedCandidates) Len() int {
+	return len(r.cands)
+}
+
+func (r *rankedCandidates) Less(i, j int) bool {
+	return r.ranks[i] > r.ranks[j]
+}
+
+func (r *rankedCandidates) Swap(i, j int) {
+	r.cands[i], r.cands[j] = r.cands[j], r.cands[i]
+	r.ranks[i], r.ranks[j] = r.ranks[j], r.ranks[i]
+}
+
+// fuzzyRank combines the fuzzy score of a candidate with its clang
+// priority, where smaller priorities are more likely.
+func fuzzyRank(score int, priority uint32) int {
+	return score - int(priority/fuzzyPriorityDiv)
+}
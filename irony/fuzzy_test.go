@@ -0,0 +1,56 @@
+package irony
+
+import "testing"
+
+func TestFuzzyScoreMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		ok      bool
+	}{
+		{"", "", true},
+		{"", "getValue", true},
+		{"gtv", "getValue", true},
+		{"GTV", "getValue", true},
+		{"gv", "get_value", true},
+		{"getValue", "getValue", true},
+		{"vg", "getValue", false},
+		{"gtx", "getValue", false},
+		{"getValues", "getValue", false},
+		{"g", "", false},
+	}
+	for _, test := range tests {
+		if _, ok := fuzzyScore(test.pattern, test.text); ok != test.ok {
+			t.Errorf("fuzzyScore(%q, %q) matches = %v, want %v", test.pattern, test.text, ok, test.ok)
+		}
+	}
+}
+
+func TestFuzzyScoreOrder(t *testing.T) {
+	tests := []struct {
+		pattern string
+		better  string
+		worse   string
+	}{
+		{"gtv", "getValue", "get_the_value_x"},
+		{"gv", "getValue", "gravel"},
+		{"val", "value", "interval"},
+		{"get", "get", "getValue"},
+	}
+	for _, test := range tests {
+		better, ok := fuzzyScore(test.pattern, test.better)
+		if !ok {
+			t.Errorf("fuzzyScore(%q, %q) does not match", test.pattern, test.better)
+			continue
+		}
+		worse, ok := fuzzyScore(test.pattern, test.worse)
+		if !ok {
+			t.Errorf("fuzzyScore(%q, %q) does not match", test.pattern, test.worse)
+			continue
+		}
+		if better <= worse {
+			t.Errorf("fuzzyScore(%q): %q scores %d, not more than %q with %d",
+				test.pattern, test.better, better, test.worse, worse)
+		}
+	}
+}
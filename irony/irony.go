@@ -0,0 +1,663 @@
+// Package irony is the engine of irony-server. An Irony session parses
+// files with libclang, keeps the unsaved buffers of its client and
+// returns diagnostics, completion candidates and types as Go values.
+// Sessions may share a TUCache and run in parallel, the libclang calls on
+// a TU are serialized by its worker. A session must not be used
+// concurrently.
+package irony
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/kangjianbin/irony-server/clang"
+	"github.com/kangjianbin/irony-server/logger"
+)
+
+const (
+	Version = "1.0.0"
+	// MaxCandidates is the page size of candidates when neither --limit
+	// nor max-candidates give one.
+	MaxCandidates = 20
+)
+
+const (
+	PrefixMatchExact uint = iota
+	PrefixMatchCaseInsensitive
+	PrefixMatchSmartCase
+	// PrefixMatchFuzzy matches subsequences and orders the candidates by
+	// score, see fuzzyScore.
+	PrefixMatchFuzzy
+)
+
+// MatchingStyles are the names of the prefix matching styles.
+var MatchingStyles = []string{"exact", "case-insensitive", "smart-case", "fuzzy"}
+
+var matchingStyleMap = map[string]uint{
+	"exact":            PrefixMatchExact,
+	"case-insensitive": PrefixMatchCaseInsensitive,
+	"smart-case":       PrefixMatchSmartCase,
+	"fuzzy":            PrefixMatchFuzzy,
+}
+
+// MatchingStyle returns the prefix matching style called name.
+func MatchingStyle(name string) (uint, bool) {
+	style, ok := matchingStyleMap[name]
+	return style, ok
+}
+
+type Irony struct {
+	cache        *TUCache
+	configs      *ConfigLoader
+	activeTd     *TUData
+	buffers      map[string]*unsavedBuffer
+	curFile      string
+	unsavedFiles []clang.UnsavedFile
+	// Generation of unsavedFiles, see unsavedGeneration.
+	unsavedGen uint64
+	actCmplRes *clang.CodeCompleteResults
+	// cmplTd is the TU actCmplRes was computed on.
+	cmplTd *TUData
+	// Configuration of the last parsed or completed file.
+	config *Config
+	// watch is set on a fork, see Fork.
+	watch *tuWatch
+}
+
+type Diagnostic struct {
+	File     string `json:"file"`
+	Line     uint32 `json:"line"`
+	Column   uint32 `json:"column"`
+	Offset   uint32 `json:"offset"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+type Candidate struct {
+	TypedText       string `json:"typed_text"`
+	Priority        uint32 `json:"priority"`
+	ResultType      string `json:"result_type"`
+	Brief           string `json:"brief"`
+	Prototype       string `json:"prototype"`
+	AnnotationStart int    `json:"annotation_start"`
+	PostCompCar     string `json:"post_completion"`
+	PostCompCdr     []int  `json:"placeholders"`
+	Availability    string `json:"availability"`
+	// Kind is the kind of the completed entity, e.g. "function".
+	Kind string `json:"kind"`
+	// Doc is the full doc comment, only filled on request.
+	Doc string `json:"doc,omitempty"`
+}
+
+type CompileCommand struct {
+	Args      []string `json:"args"`
+	Directory string   `json:"directory"`
+}
+
+// Error is reported to the client as (error . (kind "msg" args...)).
+type Error struct {
+	Kind string
+	Msg  string
+	Args []interface{}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s %v", e.Kind, e.Msg, e.Args)
+}
+
+func NewError(kind string, msg string, args ...interface{}) *Error {
+	return &Error{kind, msg, args}
+}
+
+func ClangVersion() string {
+	return clang.GetClangVersion()
+}
+
+// New creates an Irony with its own cache.
+func New(configs *ConfigLoader) *Irony {
+	return NewSession(NewTuCache(configs.Global()), configs)
+}
+
+// NewSession creates an Irony with its own active TU, unsaved files
+// and completion results on top of a possibly shared cache.
+func NewSession(cache *TUCache, configs *ConfigLoader) *Irony {
+	var app = Irony{}
+	app.cache = cache
+	app.configs = configs
+	app.buffers = make(map[string]*unsavedBuffer)
+	app.config = configs.Global()
+	return &app
+}
+
+func (irony *Irony) Cache() *TUCache {
+	return irony.cache
+}
+
+// HasTU reports whether a parse or a complete left an active TU.
+func (irony *Irony) HasTU() bool {
+	return irony.activeTd != nil
+}
+
+// HasCompletion reports whether a complete left its results.
+func (irony *Irony) HasCompletion() bool {
+	return irony.actCmplRes != nil
+}
+
+// do runs f on the worker of td, see TUData.do.
+func (irony *Irony) do(td *TUData, f func()) bool {
+	return td.doWatched(irony.watch, f)
+}
+
+// Close releases the session state but leaves the cache alone.
+func (irony *Irony) Close() {
+	irony.resetCache()
+	for file := range irony.buffers {
+		irony.removeBuffer(file)
+	}
+}
+
+func (irony *Irony) Dispose() {
+	irony.Close()
+	irony.cache.Dispose()
+}
+
+func (ir *Irony) CompileCommands(buildDir string, file string) ([]CompileCommand, error) {
+	err, db := clang.CompilationDatabaseFromDirectory(buildDir)
+	if err != clang.CompilationDatabase_NoError {
+		return nil, NewError("cannot-load-database",
+			"failed to load compilation database from directory", buildDir)
+	}
+	defer db.Dispose()
+	ccs := db.CompileCommands(file)
+	defer ccs.Dispose()
+	var cmds []CompileCommand
+	for i := uint32(0); i < ccs.Size(); i += 1 {
+		cc := ccs.Command(i)
+		var args []string
+		for j := uint32(0); j < cc.NumArgs(); j += 1 {
+			args = append(args, cc.Arg(j))
+		}
+		cmds = append(cmds, CompileCommand{args, cc.Directory()})
+	}
+	return cmds, nil
+}
+
+func (irony *Irony) resetCache() {
+	if irony.actCmplRes != nil {
+		if !irony.do(irony.cmplTd, irony.actCmplRes.Dispose) {
+			logger.Info("Leak completion results of a killed tu\n")
+		}
+		irony.actCmplRes = nil
+	}
+	if irony.cmplTd != nil {
+		irony.cmplTd.Dispose()
+		irony.cmplTd = nil
+	}
+	if irony.activeTd != nil {
+		irony.activeTd.Dispose()
+		irony.activeTd = nil
+	}
+}
+
+// SetUnsaved makes the content of the file unsaved the effective content
+// of file.
+func (irony *Irony) SetUnsaved(file string, unsaved string) error {
+	data, err := ioutil.ReadFile(unsaved)
+	if err != nil {
+		irony.removeBuffer(file)
+		return NewError("file-read-error", "failed to read unsaved buffer", file, unsaved)
+	}
+	irony.setBuffer(file, string(data), 0)
+	return nil
+}
+
+func (irony *Irony) SetUnsavedContent(file string, content string) {
+	irony.setBuffer(file, content, 0)
+}
+
+// ResetUnsaved forgets the unsaved buffer of file, its content is up to
+// date on disk.
+func (irony *Irony) ResetUnsaved(file string) {
+	irony.resetCache()
+	irony.removeBuffer(file)
+}
+
+func (irony *Irony) Parse(file string, flags []string) error {
+	irony.resetCache()
+	irony.config = irony.configs.For(file)
+	flags = irony.config.CompileFlags(flags)
+	// Only buffers owned by the client let us skip an unchanged reparse,
+	// the cache still reparses if a file changed on disk.
+	var gen uint64
+	if _, ok := irony.buffers[file]; ok {
+		gen = irony.unsavedGen
+	}
+	td := irony.cache.parse(file, flags, irony.unsavedFiles, gen, irony.watch)
+	if td == nil {
+		return NewError("parse-error", "failed to parse file", file)
+	}
+	irony.activeTd = td
+	logger.Debug("Parse %s done\n", file)
+	return nil
+}
+
+func diagnosticSeverity(diagnostic clang.Diagnostic) string {
+	switch diagnostic.Severity() {
+	case clang.Diagnostic_Ignored:
+		return "ignored"
+	case clang.Diagnostic_Note:
+		return "note"
+	case clang.Diagnostic_Warning:
+		return "warning"
+	case clang.Diagnostic_Error:
+		return "error"
+	case clang.Diagnostic_Fatal:
+		return "fatal"
+	}
+	return "unknown"
+}
+
+func newDiagnostic(diagnostic clang.Diagnostic) Diagnostic {
+	var info Diagnostic
+	location := diagnostic.Location()
+	if !location.Equal(clang.NewNullLocation()) {
+		var cxFile clang.File
+		cxFile, info.Line, info.Column, info.Offset = location.ExpansionLocation()
+		info.File = cxFile.Name()
+	}
+	info.Severity = diagnosticSeverity(diagnostic)
+	info.Message = diagnostic.Spelling()
+	return info
+}
+
+// Diagnostics returns the diagnostics of the last parse.
+func (irony *Irony) Diagnostics() []Diagnostic {
+	td := irony.activeTd
+	if td == nil {
+		logger.Info("No active tu\n")
+		return nil
+	}
+	var diags []Diagnostic
+	irony.do(td, func() {
+		count := td.tu.NumDiagnostics()
+		for i := uint32(0); i < count; i += 1 {
+			diagnostic := td.tu.Diagnostic(i)
+			diags = append(diags, newDiagnostic(diagnostic))
+			diagnostic.Dispose()
+		}
+	})
+	return diags
+}
+
+// CompletionDiagnostics returns the diagnostics of the last completion.
+func (irony *Irony) CompletionDiagnostics() []Diagnostic {
+	if irony.actCmplRes == nil {
+		logger.Info("No active completion results\n")
+		return nil
+	}
+	var diags []Diagnostic
+	irony.do(irony.cmplTd, func() {
+		count := irony.actCmplRes.NumDiagnostics()
+		for i := uint32(0); i < count; i += 1 {
+			diagnostic := irony.actCmplRes.Diagnostic(i)
+			diags = append(diags, newDiagnostic(diagnostic))
+			diagnostic.Dispose()
+		}
+	})
+	return diags
+}
+
+// Complete runs code completion at line and col of file, the candidates
+// are returned by Candidates.
+func (irony *Irony) Complete(file string, line, col uint32, flags []string) error {
+	irony.resetCache()
+	irony.config = irony.configs.For(file)
+	flags = irony.config.CompileFlags(flags)
+	td := irony.cache.genTU(file, flags, irony.unsavedFiles, irony.watch)
+	if td == nil {
+		return NewError("complete-error", "failed to perform code completion", file, line, col)
+	}
+	var res *clang.CodeCompleteResults
+	irony.do(td, func() {
+		opts := clang.DefaultCodeCompleteOptions()
+		res = td.tu.CodeCompleteAt(file, line, col, irony.unsavedFiles, opts)
+		if res != nil {
+			clang.SortCodeCompletionResults(res.Results())
+		}
+	})
+	if res == nil {
+		td.Dispose()
+		return NewError("complete-error", "failed to perform code completion", file, line, col)
+	}
+	irony.actCmplRes = res
+	irony.cmplTd = td
+	return nil
+}
+
+func getAvaliString(avail clang.AvailabilityKind) string {
+	switch avail {
+	case clang.Availability_NotAvailable:
+		return ""
+	case clang.Availability_Available:
+		return "available"
+	case clang.Availability_Deprecated:
+		return "deprecated"
+	case clang.Availability_NotAccessible:
+		return "not-accessible"
+	}
+	return ""
+}
+
+func newCandidate(res clang.CompletionResult, filter func(string) bool) (Candidate, bool) {
+	cmplString := res.CompletionString()
+	avail := cmplString.Availability()
+	if avail == clang.Availability_NotAvailable {
+		return Candidate{}, false
+	}
+	priority := cmplString.Priority()
+	availString := getAvaliString(avail)
+	var typedtext, resultType, prototype, postCompCar string
+	var postCompCdr []int
+	var annotationStart int
+	typedTextSet := false
+	for i := uint32(0); i < cmplString.NumChunks(); i += 1 {
+		ch := ""
+		kind := cmplString.ChunkKind(i)
+		chunkText := cmplString.ChunkText(i)
+		switch kind {
+		case clang.CompletionChunk_ResultType:
+			resultType = chunkText
+		case clang.CompletionChunk_TypedText, clang.CompletionChunk_Text:
+			fallthrough
+		case clang.CompletionChunk_Placeholder, clang.CompletionChunk_Informative:
+			fallthrough
+		case clang.CompletionChunk_CurrentParameter:
+			prototype += chunkText
+		case clang.CompletionChunk_LeftParen:
+			ch = "("
+		case clang.CompletionChunk_RightParen:
+			ch = ")"
+		case clang.CompletionChunk_LeftBracket:
+			ch = "["
+		case clang.CompletionChunk_RightBracket:
+			ch = "]"
+		case clang.CompletionChunk_LeftBrace:
+			ch = "{"
+		case clang.CompletionChunk_RightBrace:
+			ch = "}"
+		case clang.CompletionChunk_LeftAngle:
+			ch = "<"
+		case clang.CompletionChunk_RightAngle:
+			ch = ">"
+		case clang.CompletionChunk_Comma:
+			ch = ", "
+		case clang.CompletionChunk_Colon:
+			ch = ":"
+		case clang.CompletionChunk_SemiColon:
+			ch = ";"
+		case clang.CompletionChunk_Equal:
+			ch = "="
+		case clang.CompletionChunk_HorizontalSpace:
+			ch = " "
+		case clang.CompletionChunk_VerticalSpace:
+			ch = "\n"
+		case clang.CompletionChunk_Optional:
+			//
+		}
+		if ch != "" {
+			prototype += string(ch)
+		}
+		if typedTextSet {
+			if ch != "" {
+				postCompCar += ch
+			} else if kind == clang.CompletionChunk_Text || kind == clang.CompletionChunk_TypedText {
+				postCompCar += chunkText
+			} else if kind == clang.CompletionChunk_Placeholder || kind == clang.CompletionChunk_CurrentParameter {
+				postCompCdr = append(postCompCdr, len(postCompCar))
+				postCompCar += chunkText
+				postCompCdr = append(postCompCdr, len(postCompCar))
+			}
+		}
+		if kind == clang.CompletionChunk_TypedText && !typedTextSet {
+			typedtext = chunkText
+			if !filter(typedtext) {
+				return Candidate{}, false
+			}
+			typedTextSet = true
+			annotationStart = len(prototype)
+		}
+	}
+	if !typedTextSet {
+		return Candidate{}, false
+	}
+	return Candidate{typedtext, priority, resultType, cmplString.BriefComment(), prototype,
+		annotationStart, postCompCar, postCompCdr, availString,
+		cursorKindName(res.CursorKind()), ""}, true
+}
+
+func sortResults(results []clang.CompletionResult) {
+	sort.Slice(results, func(i, j int) bool {
+		pi := results[i].CompletionString().Priority()
+		pj := results[j].CompletionString().Priority()
+		return pi > pj
+	})
+}
+
+func getTypedText(r clang.CompletionResult) string {
+	cmplString := r.CompletionString()
+	for i := uint32(0); i < cmplString.NumChunks(); i += 1 {
+		kind := cmplString.ChunkKind(i)
+		if kind == clang.CompletionChunk_TypedText {
+			return cmplString.ChunkText(i)
+		}
+	}
+	return ""
+}
+
+func isStyleCaseInsensitive(prefix string, style uint) bool {
+	if style == PrefixMatchSmartCase {
+		hasUpper := false
+		for _, v := range prefix {
+			if unicode.IsUpper(v) {
+				hasUpper = true
+				break
+			}
+		}
+		if !hasUpper {
+			style = PrefixMatchCaseInsensitive
+		}
+	}
+	return style == PrefixMatchCaseInsensitive
+}
+
+// CandidateOptions select the candidates and what they include.
+type CandidateOptions struct {
+	// Style is the matching style of the prefix.
+	Style uint
+	// Docs fills the Doc of the candidates.
+	Docs bool
+	// Kinds selects the kinds of the candidates, nil for all of them.
+	Kinds []string
+	// Offset is the number of matching candidates skipped, Limit the
+	// number returned at most, 0 for all of them.
+	Offset int
+	Limit  int
+}
+
+// CandidatePage is a window of the matching candidates.
+type CandidatePage struct {
+	// Total is the number of matching candidates.
+	Total      int         `json:"total"`
+	Offset     int         `json:"offset"`
+	Candidates []Candidate `json:"candidates"`
+}
+
+// Candidates returns the completion candidates matching prefix, and
+// false if there was no completion. A nil opts is the zero options.
+func (irony *Irony) Candidates(prefix string, opts *CandidateOptions) (*CandidatePage, bool) {
+	if irony.actCmplRes == nil {
+		return nil, false
+	}
+	if opts == nil {
+		opts = &CandidateOptions{}
+	}
+	style := opts.Style
+
+	cmpl := irony.actCmplRes
+	var filter func(string) bool
+	// score is the fuzzy score of the last candidate accepted by filter.
+	var score int
+
+	caseInsensitive := isStyleCaseInsensitive(prefix, style)
+	if style == PrefixMatchFuzzy {
+		filter = func(text string) bool {
+			var ok bool
+			score, ok = fuzzyScore(prefix, text)
+			return ok
+		}
+	} else if caseInsensitive {
+		prefix = strings.ToLower(prefix)
+		filter = func(text string) bool {
+			if len(text) < len(prefix) {
+				return false
+			}
+			return strings.ToLower(text[:len(prefix)]) == prefix
+		}
+	} else {
+		filter = func(text string) bool {
+			return strings.HasPrefix(text, prefix)
+		}
+	}
+
+	var kinds map[string]bool
+	if opts.Kinds != nil {
+		kinds = make(map[string]bool)
+		for _, kind := range opts.Kinds {
+			kinds[kind] = true
+		}
+	}
+	var cands []Candidate
+	var ranks []int
+	ok := irony.do(irony.cmplTd, func() {
+		for _, res := range cmpl.Results() {
+			if kinds != nil && !kinds[cursorKindName(res.CursorKind())] {
+				continue
+			}
+			if cand, ok := newCandidate(res, filter); ok {
+				if opts.Docs {
+					cand.Doc = irony.cmplTd.docFor(res, cand.TypedText)
+				}
+				cands = append(cands, cand)
+				ranks = append(ranks, fuzzyRank(score, cand.Priority))
+			}
+		}
+	})
+	if !ok {
+		return nil, false
+	}
+	if style == PrefixMatchFuzzy {
+		sort.Stable(&rankedCandidates{cands, ranks})
+	}
+	return pageCandidates(cands, opts.Offset, opts.Limit), true
+}
+
+func pageCandidates(cands []Candidate, offset, limit int) *CandidatePage {
+	page := &CandidatePage{Total: len(cands), Offset: offset}
+	if offset >= len(cands) {
+		return page
+	}
+	cands = cands[offset:]
+	if limit > 0 && limit < len(cands) {
+		cands = cands[:limit]
+	}
+	page.Candidates = cands
+	return page
+}
+
+// CompletionContext describes the completion point of the last complete.
+type CompletionContext struct {
+	// Contexts are the kinds of results fit for the completion point,
+	// e.g. dot-member-access.
+	Contexts []string `json:"contexts"`
+	// ContainerKind is the kind of the entity the completion point is in,
+	// e.g. the struct of an accessed member, "" if there is none.
+	ContainerKind string `json:"container_kind"`
+	ContainerUSR  string `json:"container_usr"`
+	// ContainerIncomplete is set if libclang could not fully resolve the
+	// container.
+	ContainerIncomplete bool `json:"container_incomplete"`
+}
+
+// CompletionContext returns the context of the last completion, and
+// false if there was no completion.
+func (irony *Irony) CompletionContext() (*CompletionContext, bool) {
+	if irony.actCmplRes == nil {
+		return nil, false
+	}
+	cmpl := irony.actCmplRes
+	ctx := &CompletionContext{}
+	ok := irony.do(irony.cmplTd, func() {
+		ctx.Contexts = completionContexts(cmpl.Contexts())
+		kind, incomplete := cmpl.ContainerKind()
+		if kind != clang.Cursor_InvalidCode {
+			ctx.ContainerKind = cursorKindName(kind)
+			ctx.ContainerUSR = cmpl.ContainerUSR()
+		}
+		ctx.ContainerIncomplete = incomplete
+	})
+	if !ok {
+		return nil, false
+	}
+	return ctx, true
+}
+
+// Config returns the configuration of the last parsed or completed file.
+func (irony *Irony) Config() *Config {
+	return irony.config
+}
+
+// ConfigFor returns the configuration of file.
+func (irony *Irony) ConfigFor(file string) *Config {
+	return irony.configs.For(file)
+}
+
+// TypeAt returns the type and the canonical type at line and col of the
+// last parsed file, nil if there is nothing.
+func (irony *Irony) TypeAt(line, col uint32) []string {
+	td := irony.activeTd
+	if td == nil {
+		logger.Info("W: get-type -parse wasn't called\n")
+		return nil
+	}
+	var types []string
+	irony.do(td, func() {
+		types = typeAt(td, line, col)
+	})
+	return types
+}
+
+func typeAt(td *TUData, line, col uint32) []string {
+	tu := td.tu
+	cxFile := tu.File(td.file)
+	srcLoc := tu.Location(cxFile, line, col)
+	cursor := tu.Cursor(srcLoc)
+	if cursor.IsNull() {
+		return nil
+	}
+
+	types := []string{}
+	var cxTypes [2]clang.Type
+	cxTypes[0] = cursor.Type()
+	cxTypes[1] = cxTypes[0].CanonicalType()
+	for _, t := range cxTypes {
+		typeDesc := t.Spelling()
+		if typeDesc == "" {
+			break
+		}
+		types = append(types, typeDesc)
+	}
+	return types
+}
@@ -0,0 +1,34 @@
+package irony
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPageCandidates(t *testing.T) {
+	cands := []Candidate{{TypedText: "a"}, {TypedText: "b"}, {TypedText: "c"}}
+	tests := []struct {
+		offset int
+		limit  int
+		want   []string
+	}{
+		{0, 0, []string{"a", "b", "c"}},
+		{0, 2, []string{"a", "b"}},
+		{1, 0, []string{"b", "c"}},
+		{1, 1, []string{"b"}},
+		{2, 5, []string{"c"}},
+		{3, 0, nil},
+		{4, 1, nil},
+	}
+	for _, test := range tests {
+		page := pageCandidates(cands, test.offset, test.limit)
+		var got []string
+		for _, cand := range page.Candidates {
+			got = append(got, cand.TypedText)
+		}
+		if !reflect.DeepEqual(got, test.want) || page.Total != len(cands) || page.Offset != test.offset {
+			t.Errorf("pageCandidates(%d, %d) = %v of %d at %d, want %v of %d at %d",
+				test.offset, test.limit, got, page.Total, page.Offset, test.want, len(cands), test.offset)
+		}
+	}
+}
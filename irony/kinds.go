@@ -0,0 +1,133 @@
+package irony
+
+import (
+	"github.com/kangjianbin/irony-server/clang"
+)
+
+// cursorKindNames are the names reported for the cursor kinds, they do
+// not follow the libclang spelling which may change between versions.
+var cursorKindNames = map[clang.CursorKind]string{
+	clang.Cursor_UnexposedDecl:                      "unexposed",
+	clang.Cursor_StructDecl:                         "struct",
+	clang.Cursor_UnionDecl:                          "union",
+	clang.Cursor_ClassDecl:                          "class",
+	clang.Cursor_EnumDecl:                           "enum",
+	clang.Cursor_FieldDecl:                          "field",
+	clang.Cursor_EnumConstantDecl:                   "enum-constant",
+	clang.Cursor_FunctionDecl:                       "function",
+	clang.Cursor_VarDecl:                            "variable",
+	clang.Cursor_ParmDecl:                           "parameter",
+	clang.Cursor_ObjCInterfaceDecl:                  "objc-interface",
+	clang.Cursor_ObjCCategoryDecl:                   "objc-category",
+	clang.Cursor_ObjCProtocolDecl:                   "objc-protocol",
+	clang.Cursor_ObjCPropertyDecl:                   "objc-property",
+	clang.Cursor_ObjCIvarDecl:                       "objc-ivar",
+	clang.Cursor_ObjCInstanceMethodDecl:             "objc-instance-method",
+	clang.Cursor_ObjCClassMethodDecl:                "objc-class-method",
+	clang.Cursor_ObjCImplementationDecl:             "objc-implementation",
+	clang.Cursor_ObjCCategoryImplDecl:               "objc-category-implementation",
+	clang.Cursor_TypedefDecl:                        "typedef",
+	clang.Cursor_CXXMethod:                          "method",
+	clang.Cursor_Namespace:                          "namespace",
+	clang.Cursor_LinkageSpec:                        "linkage-spec",
+	clang.Cursor_Constructor:                        "constructor",
+	clang.Cursor_Destructor:                         "destructor",
+	clang.Cursor_ConversionFunction:                 "conversion-function",
+	clang.Cursor_TemplateTypeParameter:              "template-type-parameter",
+	clang.Cursor_NonTypeTemplateParameter:           "non-type-template-parameter",
+	clang.Cursor_TemplateTemplateParameter:          "template-template-parameter",
+	clang.Cursor_FunctionTemplate:                   "function-template",
+	clang.Cursor_ClassTemplate:                      "class-template",
+	clang.Cursor_ClassTemplatePartialSpecialization: "class-template-partial-specialization",
+	clang.Cursor_NamespaceAlias:                     "namespace-alias",
+	clang.Cursor_UsingDirective:                     "using-directive",
+	clang.Cursor_UsingDeclaration:                   "using-declaration",
+	clang.Cursor_TypeAliasDecl:                      "type-alias",
+	clang.Cursor_ObjCSynthesizeDecl:                 "objc-synthesize",
+	clang.Cursor_ObjCDynamicDecl:                    "objc-dynamic",
+	clang.Cursor_CXXAccessSpecifier:                 "access-specifier",
+	clang.Cursor_NotImplemented:                     "keyword",
+	clang.Cursor_MacroDefinition:                    "macro",
+	clang.Cursor_TypeAliasTemplateDecl:              "type-alias-template",
+}
+
+// cursorKindName returns the name of kind, "other" for the kinds not
+// expected in completions.
+func cursorKindName(kind clang.CursorKind) string {
+	if name, ok := cursorKindNames[kind]; ok {
+		return name
+	}
+	return "other"
+}
+
+// cursorKindGroups are the kinds selected by a group name.
+var cursorKindGroups = map[string][]string{
+	"members": {"field", "method", "constructor", "destructor", "conversion-function",
+		"objc-property", "objc-ivar", "objc-instance-method", "objc-class-method"},
+	"types": {"struct", "union", "class", "enum", "typedef", "type-alias", "type-alias-template",
+		"class-template", "class-template-partial-specialization", "template-type-parameter",
+		"objc-interface", "objc-protocol"},
+}
+
+// CursorKinds returns the kinds selected by name, a kind or a group of
+// kinds, and false if it is neither.
+func CursorKinds(name string) ([]string, bool) {
+	if kinds, ok := cursorKindGroups[name]; ok {
+		return kinds, true
+	}
+	if name == "other" {
+		return []string{name}, true
+	}
+	for _, kind := range cursorKindNames {
+		if kind == name {
+			return []string{name}, true
+		}
+	}
+	return nil, false
+}
+
+// completionContextNames are the names of the completion context bits,
+// in bit order.
+var completionContextNames = []struct {
+	context clang.CompletionContext
+	name    string
+}{
+	{clang.CompletionContext_AnyType, "any-type"},
+	{clang.CompletionContext_AnyValue, "any-value"},
+	{clang.CompletionContext_ObjCObjectValue, "objc-object-value"},
+	{clang.CompletionContext_ObjCSelectorValue, "objc-selector-value"},
+	{clang.CompletionContext_CXXClassTypeValue, "cxx-class-type-value"},
+	{clang.CompletionContext_DotMemberAccess, "dot-member-access"},
+	{clang.CompletionContext_ArrowMemberAccess, "arrow-member-access"},
+	{clang.CompletionContext_ObjCPropertyAccess, "objc-property-access"},
+	{clang.CompletionContext_EnumTag, "enum-tag"},
+	{clang.CompletionContext_UnionTag, "union-tag"},
+	{clang.CompletionContext_StructTag, "struct-tag"},
+	{clang.CompletionContext_ClassTag, "class-tag"},
+	{clang.CompletionContext_Namespace, "namespace"},
+	{clang.CompletionContext_NestedNameSpecifier, "nested-name-specifier"},
+	{clang.CompletionContext_ObjCInterface, "objc-interface"},
+	{clang.CompletionContext_ObjCProtocol, "objc-protocol"},
+	{clang.CompletionContext_ObjCCategory, "objc-category"},
+	{clang.CompletionContext_ObjCInstanceMessage, "objc-instance-message"},
+	{clang.CompletionContext_ObjCClassMessage, "objc-class-message"},
+	{clang.CompletionContext_ObjCSelectorName, "objc-selector-name"},
+	{clang.CompletionContext_MacroName, "macro-name"},
+	{clang.CompletionContext_NaturalLanguage, "natural-language"},
+	{clang.CompletionContext_IncludedFile, "included-file"},
+}
+
+// completionContexts returns the names of the bits set in mask, "unknown"
+// alone if libclang could not tell the context.
+func completionContexts(mask clang.CompletionContext) []string {
+	if mask == clang.CompletionContext_Unknown {
+		return []string{"unknown"}
+	}
+	names := []string{}
+	for _, c := range completionContextNames {
+		if mask&c.context != 0 {
+			names = append(names, c.name)
+		}
+	}
+	return names
+}
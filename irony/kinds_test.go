@@ -0,0 +1,28 @@
+package irony
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kangjianbin/irony-server/clang"
+)
+
+func TestCompletionContexts(t *testing.T) {
+	tests := []struct {
+		mask clang.CompletionContext
+		want []string
+	}{
+		{0, []string{}},
+		{clang.CompletionContext_Unknown, []string{"unknown"}},
+		{clang.CompletionContext_DotMemberAccess, []string{"dot-member-access"}},
+		{clang.CompletionContext_DotMemberAccess | clang.CompletionContext_AnyType,
+			[]string{"any-type", "dot-member-access"}},
+		{clang.CompletionContext_MacroName | clang.CompletionContext_IncludedFile,
+			[]string{"macro-name", "included-file"}},
+	}
+	for _, test := range tests {
+		if got := completionContexts(test.mask); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("completionContexts(%#x) = %q, want %q", uint64(test.mask), got, test.want)
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package irony
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kangjianbin/irony-server/clang"
+	"github.com/kangjianbin/irony-server/logger"
+)
+
+// TUData is a cached TU. libclang requires the calls on a TU to be
+// serialized, they run on the worker goroutine of the TU through do, so
+// that sessions working on different TUs run in parallel.
+//
+// The TU of a command that timed out is killed: its worker may be stuck
+// in libclang for good, so do fails at once instead of waiting for it.
+// The worker disposes the TU if libclang ever returns and the last
+// reference is dropped, else the TU is leaked. Later requests parse a new
+// TU.
+type TUData struct {
+	// index owns tu alone. libclang does not document parsing on one
+	// index from several threads as safe, and a parse stuck in libclang
+	// must not hold up the others.
+	index clang.Index
+	tu    clang.TranslationUnit
+	file  string
+	flags []string
+	ref   int32
+	// Generation of the unsaved files of the last reparse, 0 if unknown.
+	// It is only used by the worker.
+	unsavedGen uint64
+	// Doc comments of the declarations by name, built on demand and
+	// dropped by a reparse. It is only used by the worker.
+	docs map[string]string
+	jobs chan func()
+	// dead is closed by kill.
+	dead     chan struct{}
+	killOnce sync.Once
+	// released is closed when the last reference is dropped.
+	released chan struct{}
+	// disposed is only used by the worker.
+	disposed bool
+}
+
+// tuWatch records the TU a command is waiting for, so that the TU can be
+// killed if the command times out.
+type tuWatch struct {
+	mu sync.Mutex
+	td *TUData
+}
+
+func (w *tuWatch) set(td *TUData) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	w.td = td
+	w.mu.Unlock()
+}
+
+func (w *tuWatch) get() *TUData {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.td
+}
+
+type TUCache struct {
+	parseOptions uint32
+
+	// mu guards tuMap and epoch. The references held by tuMap are
+	// taken and dropped with mu held.
+	mu    sync.Mutex
+	tuMap map[string]*TUData
+	// epoch is bumped when the TU of a timed out command is abandoned,
+	// parses started before must not touch tuMap.
+	epoch uint64
+}
+
+// newTUData starts the worker of tu, the caller holds the first reference.
+func newTUData(index clang.Index, tu clang.TranslationUnit, file string, flags []string) *TUData {
+	td := &TUData{index: index, tu: tu, file: file, flags: flags, ref: 1,
+		jobs: make(chan func()), dead: make(chan struct{}), released: make(chan struct{})}
+	go td.work()
+	return td
+}
+
+func (td *TUData) work() {
+	for {
+		select {
+		case job, ok := <-td.jobs:
+			if !ok {
+				td.release()
+				return
+			}
+			job()
+		case <-td.dead:
+			td.release()
+			return
+		}
+	}
+}
+
+// release disposes a killed TU once the last reference is dropped. It
+// runs on the worker, back from libclang.
+func (td *TUData) release() {
+	<-td.released
+	if !td.disposed {
+		logger.Debug("Release killed tu for %s\n", td.file)
+		td.dispose()
+	}
+}
+
+// kill gives up on the TU, see TUData.
+func (td *TUData) kill() {
+	td.killOnce.Do(func() {
+		logger.Info("Kill tu for file %s\n", td.file)
+		close(td.dead)
+	})
+}
+
+func (td *TUData) isDead() bool {
+	select {
+	case <-td.dead:
+		return true
+	default:
+		return false
+	}
+}
+
+// do runs f on the worker of the TU and waits for it. It returns false
+// without running f, or without waiting for it, if the TU is killed. A
+// panic of f is raised again in the caller. f must not call do itself.
+func (td *TUData) do(f func()) bool {
+	done := make(chan interface{}, 1)
+	job := func() {
+		defer func() {
+			done <- recover()
+		}()
+		f()
+	}
+	select {
+	case td.jobs <- job:
+	case <-td.dead:
+		return false
+	}
+	select {
+	case e := <-done:
+		if e != nil {
+			panic(e)
+		}
+		return true
+	case <-td.dead:
+		return false
+	}
+}
+
+// doWatched is do recording td in w while waiting.
+func (td *TUData) doWatched(w *tuWatch, f func()) bool {
+	w.set(td)
+	defer w.set(nil)
+	return td.do(f)
+}
+
+// Dispose drops a reference, the last one disposes the TU and stops its
+// worker.
+func (td *TUData) Dispose() {
+	ref := atomic.AddInt32(&td.ref, -1)
+	if ref < 0 {
+		msg := fmt.Sprintf("tu for file %s, ref %d", td.file, ref)
+		panic(msg)
+	}
+
+	if ref == 0 {
+		logger.Debug("Release tu for %s\n", td.file)
+		close(td.released)
+		if !td.do(td.dispose) {
+			logger.Info("Killed tu for %s is released by its worker\n", td.file)
+		}
+		close(td.jobs)
+	}
+}
+
+// filesChanged reports whether a file of the TU was modified on disk
+// since libclang read it. It must run on the worker.
+func (td *TUData) filesChanged() bool {
+	changed := false
+	td.tu.Inclusions(func(file clang.File, stack []clang.SourceLocation) {
+		if changed {
+			return
+		}
+		fi, err := os.Stat(file.Name())
+		if err != nil || !fi.ModTime().Truncate(time.Second).Equal(file.Time()) {
+			logger.Debug("File %s changed on disk\n", file.Name())
+			changed = true
+		}
+	})
+	return changed
+}
+
+// dispose releases the TU and its index, on the worker.
+func (td *TUData) dispose() {
+	td.tu.Dispose()
+	td.index.Dispose()
+	td.disposed = true
+}
+
+func (td *TUData) Ref() {
+	atomic.AddInt32(&td.ref, 1)
+}
+
+func (td *TUData) refCount() int32 {
+	return atomic.LoadInt32(&td.ref)
+}
+
+func flagsIsMatch(flags1 []string, flags2 []string) bool {
+	if len(flags1) != len(flags2) {
+		return false
+	}
+	for i := range flags1 {
+		if flags1[i] != flags2[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// NewTuCache creates a cache parsing with the options of cfg.
+func NewTuCache(cfg *Config) *TUCache {
+	var tc TUCache
+
+	tc.parseOptions = cfg.ParseOptionBits()
+	tc.tuMap = make(map[string]*TUData)
+	return &tc
+}
+
+// Dispose releases the cached TUs.
+func (tc *TUCache) Dispose() {
+	tc.mu.Lock()
+	for file, td := range tc.tuMap {
+		delete(tc.tuMap, file)
+		td.Dispose()
+	}
+	tc.mu.Unlock()
+}
+
+func (tc *TUCache) currentEpoch() uint64 {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.epoch
+}
+
+// abandon gives up on the work of a command that timed out: td, the TU
+// it was waiting for if any, is killed and forgotten unless another
+// session already replaced it, and the parses still running must not
+// touch tuMap. A later parse of the file creates a new TU.
+func (tc *TUCache) abandon(td *TUData) {
+	if td != nil {
+		td.kill()
+	}
+	tc.mu.Lock()
+	tc.epoch += 1
+	forget := td != nil && tc.tuMap[td.file] == td
+	if forget {
+		logger.Info("Abandon tu for file %s\n", td.file)
+		delete(tc.tuMap, td.file)
+	}
+	tc.mu.Unlock()
+	if forget {
+		td.Dispose()
+	}
+}
+
+// findTU returns a reference to the TU of file parsed with flags, nil
+// if there is none.
+func (tc *TUCache) findTU(file string, flags []string) *TUData {
+	tc.mu.Lock()
+	td, ok := tc.tuMap[file]
+	if !ok {
+		tc.mu.Unlock()
+		return nil
+	}
+	if !flagsIsMatch(td.flags, flags) {
+		delete(tc.tuMap, file)
+		tc.mu.Unlock()
+		td.Dispose()
+		return nil
+	}
+	td.Ref()
+	tc.mu.Unlock()
+	return td
+}
+
+// addTU caches tu and returns a reference to it. A TU of filename added
+// meanwhile by another session is replaced.
+func (tc *TUCache) addTU(filename string, flags []string, index clang.Index, tu clang.TranslationUnit, epoch uint64) *TUData {
+	td := newTUData(index, tu, filename, flags)
+	tc.mu.Lock()
+	if epoch != tc.epoch {
+		tc.mu.Unlock()
+		logger.Info("Abandoned parse of %s finished\n", filename)
+		return td
+	}
+	old := tc.tuMap[filename]
+	td.Ref()
+	tc.tuMap[filename] = td
+	tc.mu.Unlock()
+	if old != nil {
+		logger.Debug("Replace tu for file %s\n", filename)
+		old.Dispose()
+	}
+	return td
+}
+
+// deleteTU removes td from the cache if it is still there.
+func (tc *TUCache) deleteTU(td *TUData, epoch uint64) {
+	tc.mu.Lock()
+	if cur, ok := tc.tuMap[td.file]; !ok || cur != td || epoch != tc.epoch {
+		tc.mu.Unlock()
+		return
+	}
+	delete(tc.tuMap, td.file)
+	tc.mu.Unlock()
+	td.Dispose()
+}
+
+// tryParse parses filename on index, it runs on the calling goroutine
+// since the TU has no worker yet.
+func (tc *TUCache) tryParse(index clang.Index, filename string, flags []string, unsaved []clang.UnsavedFile, tu *clang.TranslationUnit) clang.ErrorCode {
+	var errCode clang.ErrorCode
+	for i := 0; i < 3; i += 1 {
+		errCode = index.ParseTranslationUnit2FullArgv(filename, flags, unsaved, tc.parseOptions, tu)
+		if errCode != clang.Error_Crashed {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return errCode
+}
+
+// Parse returns a reference to the reparsed TU of filename. The reparse
+// is skipped if the TU was last reparsed with the same non-zero unsaved
+// generation and none of its files changed on disk since.
+func (tc *TUCache) Parse(filename string, inflags []string, unsaved []clang.UnsavedFile, gen uint64) *TUData {
+	return tc.parse(filename, inflags, unsaved, gen, nil)
+}
+
+func (tc *TUCache) parse(filename string, inflags []string, unsaved []clang.UnsavedFile, gen uint64, w *tuWatch) *TUData {
+	var tu clang.TranslationUnit
+
+	epoch := tc.currentEpoch()
+	flags := append([]string{"clang"}, inflags...)
+	td := tc.findTU(filename, inflags)
+	if td == nil {
+		index := clang.NewIndex(0, 0)
+		errCode := tc.tryParse(index, filename, flags, unsaved, &tu)
+		if !tu.IsValid() {
+			logger.Info("Parse failed: %d\n", errCode)
+			index.Dispose()
+			return nil
+		}
+		logger.Debug("Create new tu for file %s\n", filename)
+		td = tc.addTU(filename, inflags, index, tu, epoch)
+	} else {
+		logger.Debug("Reusing tu for file %s, cnt: %d\n", filename, td.refCount())
+	}
+	var err clang.ErrorCode
+	ok := td.doWatched(w, func() {
+		if gen != 0 && td.unsavedGen == gen && !td.filesChanged() {
+			logger.Debug("Files unchanged, skip reparsing %s\n", filename)
+			return
+		}
+		err = td.tu.ReparseTranslationUnit(unsaved, td.tu.DefaultReparseOptions())
+		td.docs = nil
+		if err == 0 {
+			td.unsavedGen = gen
+		} else {
+			td.unsavedGen = 0
+		}
+	})
+	if !ok {
+		td.Dispose()
+		return nil
+	}
+	if err != 0 {
+		logger.Info("ReParse failed, err %d\n", err)
+		tc.deleteTU(td, epoch)
+		td.Dispose()
+		return nil
+	}
+	return td
+}
+
+// GenTU returns a reference to the TU of file, it is only parsed if it
+// is not cached yet.
+func (tc *TUCache) GenTU(file string, flags []string, unsaved []clang.UnsavedFile) *TUData {
+	return tc.genTU(file, flags, unsaved, nil)
+}
+
+func (tc *TUCache) genTU(file string, flags []string, unsaved []clang.UnsavedFile, w *tuWatch) *TUData {
+	td := tc.findTU(file, flags)
+	if td != nil {
+		logger.Debug("Gen Reusing tu for file %s, cnt: %d\n", file, td.refCount())
+		return td
+	}
+	return tc.parse(file, flags, unsaved, 0, w)
+}
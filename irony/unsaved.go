@@ -0,0 +1,167 @@
+package irony
+
+import (
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+
+	"github.com/kangjianbin/irony-server/clang"
+)
+
+// unsavedBuffer is the client side content of a file. version counts the
+// edits applied since the content was last set as a whole.
+type unsavedBuffer struct {
+	content string
+	version uint32
+	unsaved clang.UnsavedFile
+}
+
+// Edit replaces the text between two positions, given as 1-based lines
+// and byte columns, with Text.
+type Edit struct {
+	StartLine, StartCol uint32
+	EndLine, EndCol     uint32
+	Text                string
+}
+
+// unsavedGeneration is bumped on every change of an unsaved buffer. It is
+// global so that generations stay unique across sessions sharing a cache.
+var unsavedGeneration uint64
+
+// Content returns the unsaved buffer of file.
+func (irony *Irony) Content(file string) (string, bool) {
+	buf, ok := irony.buffers[file]
+	if !ok {
+		return "", false
+	}
+	return buf.content, true
+}
+
+// computeUnsaved rebuilds the unsaved file list from the buffers. The C
+// strings are owned by the buffers and left alone.
+func (irony *Irony) computeUnsaved() {
+	irony.unsavedFiles = irony.unsavedFiles[:0]
+	for _, buf := range irony.buffers {
+		irony.unsavedFiles = append(irony.unsavedFiles, buf.unsaved)
+	}
+	irony.unsavedGen = atomic.AddUint64(&unsavedGeneration, 1)
+}
+
+func (irony *Irony) setBuffer(file string, content string, version uint32) {
+	buf, ok := irony.buffers[file]
+	if ok {
+		buf.version = version
+		if buf.content == content {
+			return
+		}
+		buf.unsaved.Dispose()
+	} else {
+		buf = &unsavedBuffer{}
+		irony.buffers[file] = buf
+	}
+	buf.content = content
+	buf.unsaved = clang.NewUnsavedFile(file, content)
+	irony.computeUnsaved()
+}
+
+func (irony *Irony) removeBuffer(file string) {
+	buf, ok := irony.buffers[file]
+	if !ok {
+		return
+	}
+	buf.unsaved.Dispose()
+	delete(irony.buffers, file)
+	irony.computeUnsaved()
+}
+
+// lineColOffset returns the byte offset of line and col in content. The
+// column may point just past the end of the line.
+func lineColOffset(content string, line uint32, col uint32) (int, bool) {
+	if line < 1 || col < 1 {
+		return 0, false
+	}
+	offset := 0
+	for i := uint32(1); i < line; i += 1 {
+		nl := strings.IndexByte(content[offset:], '\n')
+		if nl < 0 {
+			return 0, false
+		}
+		offset += nl + 1
+	}
+	length := strings.IndexByte(content[offset:], '\n')
+	if length < 0 {
+		length = len(content) - offset
+	}
+	if int(col-1) > length {
+		return 0, false
+	}
+	return offset + int(col-1), true
+}
+
+// EditUnsaved applies edit to the buffer of file. version must follow the
+// current version of the buffer, a file without buffer is read from disk
+// and starts at version 0.
+func (irony *Irony) EditUnsaved(file string, version uint32, edit *Edit) error {
+	buf, ok := irony.buffers[file]
+	if !ok {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return NewError("file-read-error", "failed to read file", file)
+		}
+		irony.setBuffer(file, string(data), 0)
+		buf = irony.buffers[file]
+	}
+	if version != buf.version+1 {
+		return NewError("version-mismatch", "out-of-order edit", file, buf.version+1, version)
+	}
+	start, ok := lineColOffset(buf.content, edit.StartLine, edit.StartCol)
+	end, ok2 := lineColOffset(buf.content, edit.EndLine, edit.EndCol)
+	if !ok || !ok2 || end < start {
+		return NewError("invalid-range", "invalid edit range", file,
+			edit.StartLine, edit.StartCol, edit.EndLine, edit.EndCol)
+	}
+	content := buf.content[:start] + edit.Text + buf.content[end:]
+	irony.setBuffer(file, content, version)
+	return nil
+}
+
+// Fork returns a copy of the session for a command that may time out.
+// The command runs on the fork, then Join takes its state back, or
+// Detach leaves it behind.
+func (irony *Irony) Fork() *Irony {
+	fork := *irony
+	fork.watch = &tuWatch{}
+	return &fork
+}
+
+// Join continues with the state of fork once its command returned.
+func (irony *Irony) Join(fork *Irony) {
+	*irony = *fork
+	irony.watch = nil
+}
+
+// Detach leaves the libclang state in use by the command running on fork
+// and continues with fresh copies. The TU the command waits for is
+// killed, so that other sessions do not wait for it either. Once the
+// command returns, Release on fork drops its TUs; its unsaved files are
+// leaked, there is no telling when libclang is done with them.
+func (irony *Irony) Detach(fork *Irony) {
+	irony.cache.abandon(fork.watch.get())
+	irony.actCmplRes = nil
+	irony.cmplTd = nil
+	irony.activeTd = nil
+	buffers := irony.buffers
+	irony.buffers = make(map[string]*unsavedBuffer)
+	irony.unsavedFiles = nil
+	for name, buf := range buffers {
+		irony.buffers[name] = &unsavedBuffer{buf.content, buf.version,
+			clang.NewUnsavedFile(name, buf.content)}
+	}
+	irony.computeUnsaved()
+}
+
+// Release drops the TUs and the completion results of a detached fork,
+// see Detach.
+func (irony *Irony) Release() {
+	irony.resetCache()
+}
@@ -0,0 +1,48 @@
+package irony
+
+import "testing"
+
+func TestEditUnsaved(t *testing.T) {
+	const content = "int a;\nint bc;\n"
+	tests := []struct {
+		version uint32
+		edit    Edit
+		want    string
+		// err is the kind of the expected error.
+		err string
+	}{
+		{1, Edit{1, 1, 1, 1, "// x\n"}, "// x\nint a;\nint bc;\n", ""},
+		{1, Edit{1, 5, 1, 6, "x"}, "int x;\nint bc;\n", ""},
+		{1, Edit{2, 5, 2, 7, ""}, "int a;\nint ;\n", ""},
+		{1, Edit{1, 7, 2, 1, " "}, "int a; int bc;\n", ""},
+		{1, Edit{1, 1, 3, 1, ""}, "", ""},
+		{1, Edit{3, 1, 3, 1, "long d;\n"}, "int a;\nint bc;\nlong d;\n", ""},
+		{1, Edit{1, 8, 1, 8, "x"}, "", "invalid-range"},
+		{1, Edit{4, 1, 4, 1, "x"}, "", "invalid-range"},
+		{1, Edit{0, 1, 1, 1, "x"}, "", "invalid-range"},
+		{1, Edit{1, 0, 1, 1, "x"}, "", "invalid-range"},
+		{1, Edit{2, 1, 1, 1, "x"}, "", "invalid-range"},
+		{0, Edit{1, 1, 1, 1, "x"}, "", "version-mismatch"},
+		{2, Edit{1, 1, 1, 1, "x"}, "", "version-mismatch"},
+	}
+	for _, test := range tests {
+		ir := NewSession(nil, NewConfigLoader(DefaultConfig()))
+		ir.SetUnsavedContent("t.c", content)
+		err := ir.EditUnsaved("t.c", test.version, &test.edit)
+		got, _ := ir.Content("t.c")
+		ir.Close()
+		if test.err != "" {
+			if e, ok := err.(*Error); !ok || e.Kind != test.err {
+				t.Errorf("EditUnsaved(%d, %+v) = %v, want a %s error", test.version, test.edit, err, test.err)
+			} else if got != content {
+				t.Errorf("EditUnsaved(%d, %+v) failed but changed the content to %q", test.version, test.edit, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("EditUnsaved(%d, %+v) failed: %s", test.version, test.edit, err)
+		} else if got != test.want {
+			t.Errorf("EditUnsaved(%d, %+v) = %q, want %q", test.version, test.edit, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/kangjianbin/irony-server/irony"
+	"github.com/kangjianbin/irony-server/logger"
+)
+
+// JSON-RPC 2.0 error codes.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	// Reserved for implementation-defined server errors.
+	rpcServerError = -32000
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// isNotification reports whether the request expects no response.
+func (req *rpcRequest) isNotification() bool {
+	return req.ID == nil
+}
+
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result"`
+}
+
+type rpcErrorResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Error   *rpcError       `json:"error"`
+}
+
+type rpcNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// readMessage reads one Content-Length framed message.
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			return nil, fmt.Errorf("invalid header line %q", line)
+		}
+		name := strings.TrimSpace(line[:colon])
+		value := strings.TrimSpace(line[colon+1:])
+		if strings.EqualFold(name, "Content-Length") {
+			length, err = strconv.Atoi(value)
+			if err != nil || length < 0 {
+				return nil, fmt.Errorf("invalid Content-Length %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	logger.Debug("Recv %s\n", data)
+	return data, nil
+}
+
+// writeMessage writes v as a Content-Length framed JSON message.
+func writeMessage(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	logger.Debug("Send %s\n", data)
+	_, err = fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	return err
+}
+
+func writeResult(w io.Writer, id json.RawMessage, result interface{}) error {
+	return writeMessage(w, &rpcResponse{"2.0", id, result})
+}
+
+func writeError(w io.Writer, id json.RawMessage, code int, msg string, data interface{}) error {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return writeMessage(w, &rpcErrorResponse{"2.0", id, &rpcError{code, msg, data}})
+}
+
+func writeNotification(w io.Writer, method string, params interface{}) error {
+	return writeMessage(w, &rpcNotification{"2.0", method, params})
+}
+
+// JSON-RPC transport for the command registry: every CommandDef is a
+// method whose named params follow its argument schema, "flags" holds
+// the compile options.
+
+func rpcArgString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		if v {
+			return "on", nil
+		}
+		return "off", nil
+	}
+	return "", fmt.Errorf("unsupported parameter value %v", v)
+}
+
+func rpcFlags(v interface{}) ([]string, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("flags must be an array of strings")
+	}
+	flags := []string{}
+	for _, flag := range list {
+		s, ok := flag.(string)
+		if !ok {
+			return nil, fmt.Errorf("flags must be an array of strings")
+		}
+		flags = append(flags, s)
+	}
+	return flags, nil
+}
+
+// rpcArgs converts by-name or by-position params into the words
+// accepted by CommandDef.parseArgs.
+func rpcArgs(cmd *CommandDef, raw json.RawMessage) ([]string, error) {
+	args := []string{cmd.Name}
+	if len(raw) == 0 || string(raw) == "null" {
+		return args, nil
+	}
+	var positional []interface{}
+	if err := json.Unmarshal(raw, &positional); err == nil {
+		for _, v := range positional {
+			s, err := rpcArgString(v)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, s)
+		}
+		return args, nil
+	}
+	var named map[string]interface{}
+	if err := json.Unmarshal(raw, &named); err != nil {
+		return nil, fmt.Errorf("params must be an object or an array")
+	}
+	// Payload commands take the content itself, its size is implied.
+	content, hasContent := named["content"].(string)
+	if hasContent && cmd.Payload {
+		delete(named, "content")
+		named["nbytes"] = float64(len(content))
+	}
+	for name := range named {
+		if name == "flags" && cmd.CompileOptions {
+			continue
+		}
+		if cmd.arg(name) == nil && cmd.option(name) == nil {
+			return nil, fmt.Errorf("unknown parameter %s", name)
+		}
+	}
+	missing := ""
+	for _, arg := range cmd.Args {
+		v, ok := named[arg.Name]
+		if !ok {
+			if missing == "" {
+				missing = arg.Name
+			}
+			continue
+		}
+		if missing != "" {
+			return nil, fmt.Errorf("missing parameter %s", missing)
+		}
+		s, err := rpcArgString(v)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, s)
+	}
+	for _, opt := range cmd.Options {
+		if v, ok := named[opt.Name]; ok {
+			s, err := rpcArgString(v)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, "--"+opt.Name+"="+s)
+		}
+	}
+	if v, ok := named["flags"]; ok {
+		flags, err := rpcFlags(v)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--")
+		args = append(args, flags...)
+	}
+	if hasContent && cmd.Payload {
+		args = append(args, content)
+	}
+	return args, nil
+}
+
+func stringInSlice(s string, list []string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func rpcHelp() interface{} {
+	var methods []map[string]interface{}
+	for _, cmd := range Commands {
+		params := []map[string]interface{}{}
+		for _, arg := range cmd.Args {
+			param := map[string]interface{}{
+				"name":     arg.Name,
+				"type":     argKindNames[arg.Kind],
+				"optional": arg.Optional,
+			}
+			if arg.Values != nil {
+				param["values"] = arg.Values
+			}
+			params = append(params, param)
+		}
+		for _, opt := range cmd.Options {
+			param := map[string]interface{}{
+				"name":     opt.Name,
+				"type":     argKindNames[opt.Kind],
+				"optional": true,
+			}
+			if opt.Values != nil {
+				param["values"] = opt.Values
+			}
+			params = append(params, param)
+		}
+		if cmd.CompileOptions {
+			params = append(params, map[string]interface{}{
+				"name":     "flags",
+				"type":     "array",
+				"optional": true,
+			})
+		}
+		methods = append(methods, map[string]interface{}{
+			"name":        cmd.Name,
+			"description": cmd.Desc,
+			"params":      params,
+		})
+	}
+	return methods
+}
+
+// callCommand runs the command registered as method and returns its result.
+func callCommand(s *session, enc *valueEncoder, method string, params json.RawMessage) (interface{}, *rpcError) {
+	cmd, ok := commandMap()[method]
+	if !ok {
+		return nil, &rpcError{rpcMethodNotFound, "method not found: " + method, nil}
+	}
+	if cmd.Name == "help" {
+		return rpcHelp(), nil
+	}
+	words, err := rpcArgs(cmd, params)
+	if err != nil {
+		return nil, &rpcError{rpcInvalidParams, err.Error(), nil}
+	}
+	args, err := cmd.parseArgs(words)
+	if err != nil {
+		return nil, &rpcError{rpcInvalidParams, err.Error(), newJSONError(err)}
+	}
+	enc.reset()
+	if err := execCommand(s, cmd, args); err != nil && err != errExit {
+		code := rpcInvalidParams
+		if _, ok := err.(*irony.Error); ok {
+			code = rpcServerError
+		}
+		return nil, &rpcError{code, err.Error(), newJSONError(err)}
+	}
+	if enc.err != nil {
+		e := newJSONError(enc.err)
+		return nil, &rpcError{rpcServerError, e.Message, e}
+	}
+	return enc.result, nil
+}
+
+// runJSONRPC serves Content-Length framed JSON-RPC 2.0 requests until
+// EOF or the exit method.
+func runJSONRPC(s *session, enc *valueEncoder, in io.Reader, out io.Writer) {
+	r := bufio.NewReader(in)
+	for {
+		data, err := readMessage(r)
+		if err != nil {
+			if err != io.EOF {
+				logger.Info("JSON-RPC read error: %s\n", err)
+			}
+			return
+		}
+		var req rpcRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			writeError(out, nil, rpcParseError, err.Error(), nil)
+			continue
+		}
+		if req.JSONRPC != "2.0" || req.Method == "" {
+			if !req.isNotification() {
+				writeError(out, req.ID, rpcInvalidRequest, "invalid request", nil)
+			}
+			continue
+		}
+		cmdLock.RLock()
+		result, rerr := callCommand(s, enc, req.Method, req.Params)
+		cmdLock.RUnlock()
+		if !req.isNotification() {
+			if rerr != nil {
+				writeError(out, req.ID, rerr.Code, rerr.Message, rerr.Data)
+			} else {
+				writeResult(out, req.ID, result)
+			}
+		}
+		if req.Method == "exit" {
+			return
+		}
+	}
+}
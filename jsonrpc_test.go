@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestRPCArgs(t *testing.T) {
+	tests := []struct {
+		cmd    *CommandDef
+		params string
+		want   []string
+		ok     bool
+	}{
+		{testCommand, ``, []string{"test"}, true},
+		{testCommand, `null`, []string{"test"}, true},
+		{testCommand, `["a.c", 3, "fuzzy"]`, []string{"test", "a.c", "3", "fuzzy"}, true},
+		{testCommand, `{"file": "a.c", "line": 3}`, []string{"test", "a.c", "3"}, true},
+		{testCommand, `{"file": "a.c", "line": 3, "style": "exact"}`,
+			[]string{"test", "a.c", "3", "exact"}, true},
+		{testCommand, `{"file": "a.c", "line": 3, "doc": true, "limit": 10}`,
+			[]string{"test", "a.c", "3", "--doc=on", "--limit=10"}, true},
+		{testCommand, `{"file": "a.c", "line": 3, "flags": ["-I.", "-DX"]}`,
+			[]string{"test", "a.c", "3", "--", "-I.", "-DX"}, true},
+		{testCommand, `{"file": "a.c", "style": "exact"}`, nil, false},
+		{testCommand, `{"file": "a.c", "bogus": 1}`, nil, false},
+		{testCommand, `{"file": "a.c", "line": 3, "flags": "-I."}`, nil, false},
+		{testCommand, `{"file": ["a.c"]}`, nil, false},
+		{testCommand, `"a.c"`, nil, false},
+		{testPayloadCommand, `{"file": "a.c", "content": "héllo"}`,
+			[]string{"test-payload", "a.c", "6", "héllo"}, true},
+		{testPayloadCommand, `{"file": "a.c", "nbytes": 2}`, []string{"test-payload", "a.c", "2"}, true},
+	}
+	for _, test := range tests {
+		got, err := rpcArgs(test.cmd, json.RawMessage(test.params))
+		if !test.ok {
+			if err == nil {
+				t.Errorf("rpcArgs(%s) = %q, want an error", test.params, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("rpcArgs(%s) failed: %s", test.params, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("rpcArgs(%s) = %q, want %q", test.params, got, test.want)
+		}
+	}
+}
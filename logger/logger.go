@@ -0,0 +1,72 @@
+// Package logger is the log of irony-server, shared by the engine and
+// the protocol front ends. It writes to stderr until Setup opens a file.
+package logger
+
+import (
+	"log"
+	"os"
+	"sync/atomic"
+)
+
+type fileLogger struct {
+	logger *log.Logger
+	file   *os.File
+	// debug is accessed atomically, commands of several sessions log
+	// in parallel.
+	debug int32
+}
+
+var mlogger fileLogger
+
+func init() {
+	initLogger()
+}
+
+func initLogger() {
+	mlogger.file = os.Stderr
+	mlogger.logger = log.New(mlogger.file, "", log.Ltime|log.Lmicroseconds)
+}
+
+// Setup sends the log to fileName.
+func Setup(fileName string) {
+	Release()
+	f, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return
+	}
+	logger := log.New(f, "", log.LstdFlags)
+	mlogger.logger = logger
+	mlogger.file = f
+}
+
+// Release flushes and closes the log file, later messages go to stderr.
+func Release() {
+	if mlogger.file == os.Stderr || mlogger.file == nil {
+		return
+	}
+	mlogger.file.Sync()
+	mlogger.file.Close()
+	initLogger()
+}
+
+func SetDebug(isOn bool) {
+	var v int32
+	if isOn {
+		v = 1
+	}
+	atomic.StoreInt32(&mlogger.debug, v)
+}
+
+func Debug(format string, a ...interface{}) {
+	if atomic.LoadInt32(&mlogger.debug) == 0 {
+		return
+	}
+	mlogger.logger.Printf(format, a...)
+}
+
+func Info(format string, a ...interface{}) {
+	if mlogger.logger == nil {
+		return
+	}
+	mlogger.logger.Printf(format, a...)
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/kangjianbin/irony-server/irony"
+	"github.com/kangjianbin/irony-server/logger"
+)
+
+// Minimal Language Server Protocol front end on top of Irony, speaking
+// JSON-RPC over stdio. Documents are synchronized in full and kept as
+// unsaved files; positions are converted between LSP (0-based lines,
+// UTF-16 characters) and libclang (1-based lines, byte columns).
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspTextDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type lspTextDocumentItem struct {
+	URI     string `json:"uri"`
+	Version int    `json:"version"`
+	Text    string `json:"text"`
+}
+
+type lspInitializeParams struct {
+	InitializationOptions struct {
+		CompileFlags        []string `json:"compileFlags"`
+		CompilationDatabase string   `json:"compilationDatabase"`
+	} `json:"initializationOptions"`
+}
+
+type lspDidOpenParams struct {
+	TextDocument lspTextDocumentItem `json:"textDocument"`
+}
+
+type lspDidChangeParams struct {
+	TextDocument   lspTextDocumentIdentifier `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type lspDidCloseParams struct {
+	TextDocument lspTextDocumentIdentifier `json:"textDocument"`
+}
+
+type lspTextDocumentPositionParams struct {
+	TextDocument lspTextDocumentIdentifier `json:"textDocument"`
+	Position     lspPosition               `json:"position"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspPublishDiagnosticsParams struct {
+	URI         string          `json:"uri"`
+	Diagnostics []lspDiagnostic `json:"diagnostics"`
+}
+
+type lspCompletionItem struct {
+	Label         string `json:"label"`
+	Kind          int    `json:"kind,omitempty"`
+	Detail        string `json:"detail,omitempty"`
+	Documentation string `json:"documentation,omitempty"`
+	SortText      string `json:"sortText"`
+	InsertText    string `json:"insertText"`
+}
+
+// lspItemKinds maps the candidate kinds to the CompletionItemKind of the
+// protocol, the others are left out.
+var lspItemKinds = map[string]int{
+	"method":                  2,
+	"objc-instance-method":    2,
+	"objc-class-method":       2,
+	"function":                3,
+	"function-template":       3,
+	"constructor":             4,
+	"field":                   5,
+	"objc-ivar":               5,
+	"variable":                6,
+	"parameter":               6,
+	"class":                   7,
+	"class-template":          7,
+	"objc-interface":          7,
+	"objc-protocol":           8,
+	"namespace":               9,
+	"namespace-alias":         9,
+	"objc-property":           10,
+	"enum":                    13,
+	"keyword":                 14,
+	"enum-constant":           20,
+	"macro":                   21,
+	"struct":                  22,
+	"union":                   22,
+	"typedef":                 22,
+	"type-alias":              22,
+	"template-type-parameter": 25,
+}
+
+type lspCompletionList struct {
+	IsIncomplete bool                `json:"isIncomplete"`
+	Items        []lspCompletionItem `json:"items"`
+}
+
+type lspMarkupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type lspHover struct {
+	Contents lspMarkupContent `json:"contents"`
+}
+
+type lspServer struct {
+	ir       *irony.Irony
+	out      io.Writer
+	flags    []string
+	buildDir string
+	shutdown bool
+}
+
+// runLSP serves LSP requests until the client sends exit. It returns
+// whether the client asked for a shutdown first.
+func runLSP(ir *irony.Irony, in io.Reader, out io.Writer) bool {
+	s := &lspServer{ir: ir, out: out}
+	r := bufio.NewReader(in)
+	for {
+		data, err := readMessage(r)
+		if err != nil {
+			if err != io.EOF {
+				logger.Info("LSP read error: %s\n", err)
+			}
+			return false
+		}
+		var req rpcRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			writeError(out, nil, rpcParseError, err.Error(), nil)
+			continue
+		}
+		if req.Method == "exit" {
+			return s.shutdown
+		}
+		cmdLock.RLock()
+		result, rerr := s.handle(&req)
+		cmdLock.RUnlock()
+		if req.isNotification() {
+			if rerr != nil {
+				logger.Info("LSP %s: %s\n", req.Method, rerr.Message)
+			}
+			continue
+		}
+		if rerr != nil {
+			writeError(out, req.ID, rerr.Code, rerr.Message, rerr.Data)
+		} else {
+			writeResult(out, req.ID, result)
+		}
+	}
+}
+
+func lspParams(req *rpcRequest, v interface{}) *rpcError {
+	if req.Params == nil {
+		return nil
+	}
+	if err := json.Unmarshal(req.Params, v); err != nil {
+		return &rpcError{rpcInvalidParams, err.Error(), nil}
+	}
+	return nil
+}
+
+func (s *lspServer) handle(req *rpcRequest) (interface{}, *rpcError) {
+	switch req.Method {
+	case "initialize":
+		var params lspInitializeParams
+		if err := lspParams(req, &params); err != nil {
+			return nil, err
+		}
+		s.flags = params.InitializationOptions.CompileFlags
+		s.buildDir = params.InitializationOptions.CompilationDatabase
+		return s.initialize(), nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params lspDidOpenParams
+		if err := lspParams(req, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.didOpen(&params)
+	case "textDocument/didChange":
+		var params lspDidChangeParams
+		if err := lspParams(req, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.didChange(&params)
+	case "textDocument/didClose":
+		var params lspDidCloseParams
+		if err := lspParams(req, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.didClose(&params)
+	case "textDocument/completion":
+		var params lspTextDocumentPositionParams
+		if err := lspParams(req, &params); err != nil {
+			return nil, err
+		}
+		return s.completion(&params)
+	case "textDocument/hover":
+		var params lspTextDocumentPositionParams
+		if err := lspParams(req, &params); err != nil {
+			return nil, err
+		}
+		return s.hover(&params)
+	}
+	if req.isNotification() {
+		return nil, nil
+	}
+	return nil, &rpcError{rpcMethodNotFound, "method not found: " + req.Method, nil}
+}
+
+func (s *lspServer) initialize() interface{} {
+	return map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			"textDocumentSync": map[string]interface{}{
+				"openClose": true,
+				"change":    1, // full document sync
+			},
+			"completionProvider": map[string]interface{}{
+				"triggerCharacters": []string{".", ">", ":"},
+			},
+			"hoverProvider": true,
+		},
+		"serverInfo": map[string]string{
+			"name":    myApp,
+			"version": GetVersion(),
+		},
+	}
+}
+
+func uriToPath(uri string) (string, *rpcError) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return "", &rpcError{rpcInvalidParams, "unsupported document uri " + uri, nil}
+	}
+	path := u.Path
+	if runtime.GOOS == "windows" {
+		path = strings.TrimPrefix(path, "/")
+	}
+	return filepath.FromSlash(path), nil
+}
+
+func pathToURI(path string) string {
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	u := url.URL{Scheme: "file", Path: path}
+	return u.String()
+}
+
+// compileFlagsFromCommand strips the compiler, the output and the source
+// file from a compilation database entry.
+func compileFlagsFromCommand(cc irony.CompileCommand, file string) []string {
+	var flags []string
+	for i := 1; i < len(cc.Args); i += 1 {
+		arg := cc.Args[i]
+		if arg == "-c" {
+			continue
+		}
+		if arg == "-o" {
+			i += 1
+			continue
+		}
+		if arg == file || filepath.Join(cc.Directory, arg) == file {
+			continue
+		}
+		flags = append(flags, arg)
+	}
+	return append(flags, "-working-directory="+cc.Directory)
+}
+
+func (s *lspServer) flagsFor(file string) []string {
+	if s.buildDir != "" {
+		cmds, err := s.ir.CompileCommands(s.buildDir, file)
+		if err == nil && len(cmds) > 0 {
+			return compileFlagsFromCommand(cmds[0], file)
+		}
+	}
+	return s.flags
+}
+
+func lineText(content string, line int) string {
+	for i := 0; i < line; i += 1 {
+		nl := strings.IndexByte(content, '\n')
+		if nl < 0 {
+			return ""
+		}
+		content = content[nl+1:]
+	}
+	if nl := strings.IndexByte(content, '\n'); nl >= 0 {
+		content = content[:nl]
+	}
+	return strings.TrimSuffix(content, "\r")
+}
+
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// byteColumn converts an UTF-16 character offset into a 1-based byte column.
+func byteColumn(text string, character int) uint32 {
+	units := 0
+	for i, r := range text {
+		if units >= character {
+			return uint32(i + 1)
+		}
+		units += utf16Len(r)
+	}
+	return uint32(len(text) + 1)
+}
+
+// utf16Column converts a 1-based byte column into an UTF-16 character offset.
+func utf16Column(text string, column uint32) int {
+	units := 0
+	for i, r := range text {
+		if uint32(i+1) >= column {
+			break
+		}
+		units += utf16Len(r)
+	}
+	return units
+}
+
+func lspSeverity(severity string) int {
+	switch severity {
+	case "error", "fatal":
+		return 1
+	case "warning":
+		return 2
+	case "note":
+		return 3
+	}
+	return 0
+}
+
+// parse parses file under the watchdog, like the parse command.
+func (s *lspServer) parse(file string) error {
+	flags := s.flagsFor(file)
+	_, err := runWatched(s.ir, "parse", func(engine *irony.Irony) error {
+		return engine.Parse(file, flags)
+	})
+	return err
+}
+
+func (s *lspServer) publishDiagnostics(file string) {
+	lspDiags := []lspDiagnostic{}
+	if content, ok := s.ir.Content(file); ok {
+		if err := s.parse(file); err != nil {
+			logger.Info("LSP: %s\n", err)
+		} else {
+			for _, d := range s.ir.Diagnostics() {
+				severity := lspSeverity(d.Severity)
+				if severity == 0 || filepath.Clean(d.File) != filepath.Clean(file) {
+					continue
+				}
+				line := 0
+				if d.Line > 0 {
+					line = int(d.Line) - 1
+				}
+				pos := lspPosition{line, utf16Column(lineText(content, line), d.Column)}
+				lspDiags = append(lspDiags, lspDiagnostic{lspRange{pos, pos}, severity, myApp, d.Message})
+			}
+		}
+	}
+	writeNotification(s.out, "textDocument/publishDiagnostics",
+		&lspPublishDiagnosticsParams{pathToURI(file), lspDiags})
+}
+
+func (s *lspServer) didOpen(params *lspDidOpenParams) *rpcError {
+	file, err := uriToPath(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	s.ir.SetUnsavedContent(file, params.TextDocument.Text)
+	s.publishDiagnostics(file)
+	return nil
+}
+
+func (s *lspServer) didChange(params *lspDidChangeParams) *rpcError {
+	file, err := uriToPath(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	if n := len(params.ContentChanges); n > 0 {
+		s.ir.SetUnsavedContent(file, params.ContentChanges[n-1].Text)
+	}
+	s.publishDiagnostics(file)
+	return nil
+}
+
+func (s *lspServer) didClose(params *lspDidCloseParams) *rpcError {
+	file, err := uriToPath(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	s.ir.ResetUnsaved(file)
+	s.publishDiagnostics(file)
+	return nil
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func (s *lspServer) completion(params *lspTextDocumentPositionParams) (interface{}, *rpcError) {
+	file, rerr := uriToPath(params.TextDocument.URI)
+	if rerr != nil {
+		return nil, rerr
+	}
+	content, _ := s.ir.Content(file)
+	text := lineText(content, params.Position.Line)
+	end := int(byteColumn(text, params.Position.Character)) - 1
+	start := end
+	for start > 0 && isIdentByte(text[start-1]) {
+		start -= 1
+	}
+	line := uint32(params.Position.Line + 1)
+	flags := s.flagsFor(file)
+	_, err := runWatched(s.ir, "complete", func(engine *irony.Irony) error {
+		return engine.Complete(file, line, uint32(start+1), flags)
+	})
+	if err != nil {
+		return nil, &rpcError{rpcInternalError, err.Error(), nil}
+	}
+	page, ok := s.ir.Candidates(text[start:end], &irony.CandidateOptions{
+		Style: irony.PrefixMatchSmartCase,
+		Limit: s.ir.Config().MaxCandidates,
+	})
+	if !ok {
+		return &lspCompletionList{false, []lspCompletionItem{}}, nil
+	}
+	items := []lspCompletionItem{}
+	for i, c := range page.Candidates {
+		detail := c.Prototype
+		if c.ResultType != "" {
+			detail = c.ResultType + " " + detail
+		}
+		items = append(items, lspCompletionItem{
+			Label:         c.TypedText,
+			Kind:          lspItemKinds[c.Kind],
+			Detail:        detail,
+			Documentation: c.Brief,
+			SortText:      fmt.Sprintf("%06d", i),
+			InsertText:    c.TypedText,
+		})
+	}
+	// The client asks again as the user types if the list is cut.
+	return &lspCompletionList{page.Total > len(items), items}, nil
+}
+
+func (s *lspServer) hover(params *lspTextDocumentPositionParams) (interface{}, *rpcError) {
+	file, rerr := uriToPath(params.TextDocument.URI)
+	if rerr != nil {
+		return nil, rerr
+	}
+	if err := s.parse(file); err != nil {
+		logger.Info("LSP: %s\n", err)
+		return nil, nil
+	}
+	content, _ := s.ir.Content(file)
+	text := lineText(content, params.Position.Line)
+	line := uint32(params.Position.Line + 1)
+	types := s.ir.TypeAt(line, byteColumn(text, params.Position.Character))
+	if len(types) == 0 {
+		return nil, nil
+	}
+	value := "```cpp\n" + types[0] + "\n```"
+	if len(types) > 1 && types[1] != types[0] {
+		value += "\n\ncanonical type: `" + types[1] + "`"
+	}
+	return &lspHover{lspMarkupContent{"markdown", value}}, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import "testing"
+
+// columnText holds a 1, a 2 and a 4 byte character, the last one takes
+// two UTF-16 units.
+const columnText = "aé😀b"
+
+func TestByteColumn(t *testing.T) {
+	tests := []struct {
+		character int
+		want      uint32
+	}{
+		{0, 1},
+		{1, 2},
+		{2, 4},
+		// Inside the surrogate pair of 😀.
+		{3, 8},
+		{4, 8},
+		{5, 9},
+		{10, 9},
+	}
+	for _, test := range tests {
+		if got := byteColumn(columnText, test.character); got != test.want {
+			t.Errorf("byteColumn(%q, %d) = %d, want %d", columnText, test.character, got, test.want)
+		}
+	}
+	if got := byteColumn("", 3); got != 1 {
+		t.Errorf("byteColumn(\"\", 3) = %d, want 1", got)
+	}
+}
+
+func TestUTF16Column(t *testing.T) {
+	tests := []struct {
+		column uint32
+		want   int
+	}{
+		{0, 0},
+		{1, 0},
+		{2, 1},
+		// Inside the bytes of é, which counts as passed.
+		{3, 2},
+		{4, 2},
+		{8, 4},
+		{9, 5},
+		{100, 5},
+	}
+	for _, test := range tests {
+		if got := utf16Column(columnText, test.column); got != test.want {
+			t.Errorf("utf16Column(%q, %d) = %d, want %d", columnText, test.column, got, test.want)
+		}
+	}
+}
+
+func TestLineText(t *testing.T) {
+	const content = "int a;\r\n\nint b;"
+	tests := []struct {
+		line int
+		want string
+	}{
+		{0, "int a;"},
+		{1, ""},
+		{2, "int b;"},
+		{3, ""},
+	}
+	for _, test := range tests {
+		if got := lineText(content, test.line); got != test.want {
+			t.Errorf("lineText(%q, %d) = %q, want %q", content, test.line, got, test.want)
+		}
+	}
+}
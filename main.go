@@ -4,88 +4,247 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"runtime"
 	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kangjianbin/irony-server/irony"
+	"github.com/kangjianbin/irony-server/logger"
 )
 
 var ClangHeaderDir string
 
 func showVersion() {
 	fmt.Printf("%s version %s\n", myApp, GetVersion())
-	fmt.Println(GetClangVersion())
+	fmt.Println(irony.ClangVersion())
 }
 
 func init() {
-	initLogger()
 	initCommands()
+	initOptions()
 }
 
 func release() {
 	if e := recover(); e != nil {
-		logInfo("%s: %s\n", e, debug.Stack())
+		logger.Info("%s: %s\n", e, debug.Stack())
+		exit(exitInternalError)
 	}
-	releaseLogger()
+	exit(exitOK)
 }
 
+// exitError reports a fatal error. It may run inside a command, so only
+// the temp file is removed. During an exit the error is a consequence of
+// the cleanup, the goroutine just stops.
 func exitError(format string, a ...interface{}) {
+	if isExiting() {
+		runtime.Goexit()
+	}
 	fmt.Fprintf(os.Stderr, format, a...)
-	os.Exit(1)
+	removeTempFile()
+	logger.Release()
+	os.Exit(exitFailure)
 }
 
 func main() {
 	defer release()
+	handleSignals()
 
 	argc := len(os.Args)
 	if len(os.Args) <= 1 {
 		printHelp()
 		return
 	}
-	ironyApp := NewIrony()
 	i := 1
 	var interactive = false
+	var lspMode = false
+	var rpcMode = false
+	var async = false
+	listenAddr := ""
+	httpAddr := ""
+	recordDir := ""
+	scriptFile := ""
+	format := "sexp"
 	for i < argc {
 		arg := os.Args[i]
 		if arg[0] != '-' {
 			break
 		}
 
-		if arg == "-h" || arg == "-help" {
+		if arg == "-h" || arg == "--help" || arg == "-help" {
 			printHelp()
 			return
 		} else if arg == "--version" || arg == "-v" {
 			showVersion()
 			return
 		} else if arg == "--debug" || arg == "-d" {
-			setDebug(true)
+			serverConfigs.Override("debug", "on")
 		} else if arg == "-i" || arg == "--interactive" {
 			interactive = true
-		} else if arg == "--log-file" && (i+1) < argc {
+		} else if arg == "--async" {
+			async = true
+		} else if arg == "--lsp" {
+			lspMode = true
+		} else if arg == "--jsonrpc" {
+			rpcMode = true
+		} else if arg == "--listen" && (i+1) < argc {
+			i += 1
+			listenAddr = os.Args[i]
+		} else if arg == "--http" && (i+1) < argc {
 			i += 1
-			setupLogger(os.Args[i])
+			httpAddr = os.Args[i]
+		} else if arg == "--max-payload" && (i+1) < argc {
+			i += 1
+			n, err := strconv.ParseInt(os.Args[i], 0, 64)
+			if err != nil || n < 0 {
+				exitError("Error: invalid size %s\n", os.Args[i])
+			}
+			maxPayload = n
+		} else if arg == "--allow-remote" {
+			allowRemote = true
+		} else if arg == "--script" && (i+1) < argc {
+			i += 1
+			scriptFile = os.Args[i]
+		} else if arg == "--record" && (i+1) < argc {
+			i += 1
+			recordDir = os.Args[i]
+		} else if (arg == "--parse-timeout" || arg == "--complete-timeout") && (i+1) < argc {
+			i += 1
+			timeout, err := time.ParseDuration(os.Args[i])
+			if err != nil {
+				exitError("Error: invalid duration %s\n", os.Args[i])
+			}
+			name := strings.TrimSuffix(strings.TrimPrefix(arg, "--"), "-timeout")
+			commandTimeouts[name] = timeout
+		} else if (arg == "--log-file" || arg == "--max-candidates" || arg == "--matching-style" ||
+			arg == "--parse-options" || arg == "--clang-header-dir") && (i+1) < argc {
+			i += 1
+			if err := serverConfigs.Override(strings.TrimPrefix(arg, "--"), os.Args[i]); err != nil {
+				exitError("Error: %s: %s\n", arg, err)
+			}
+		} else if strings.HasPrefix(arg, "--format=") {
+			format = strings.TrimPrefix(arg, "--format=")
 		} else {
 			exitError("Error: invalid option %s\n", arg)
 			return
 		}
 		i += 1
 	}
-	logInfo("Builtin dir: %s\n", ClangHeaderDir)
-	var nextCmdFunc func() []string
-	if interactive {
-		nextCmdFunc = getCmdFromStdin
+	enc := newResponseEncoder(format, os.Stdout)
+	if enc == nil {
+		exitError("Error: invalid format %s\n", format)
+		return
+	}
+	if err := serverConfigs.LoadUserConfig(); err != nil {
+		exitError("Error: config: %s\n", err)
+	}
+	config := serverConfigs.Global()
+	if config.LogFile != "" {
+		logger.Setup(config.LogFile)
+	}
+	logger.SetDebug(config.Debug)
+	logger.Info("Builtin dir: %s\n", config.ClangHeaderDir)
+	if i+1 < argc && os.Args[i] == "replay" {
+		diffs, err := runReplay(os.Args[i+1], os.Stdout)
+		if err != nil {
+			exitError("Error: replay: %s\n", err)
+		}
+		if diffs > 0 {
+			exit(exitFailure)
+		}
+		return
+	}
+	if i < argc && os.Args[i] == "completion" {
+		// Print the script alone, without the ;;EOT of the responses.
+		shell := ""
+		if i+1 < argc {
+			shell = os.Args[i+1]
+		}
+		script := completionScript(shell)
+		if script == "" {
+			exitError("Error: completion: SHELL must be one of %s\n", strings.Join(completionShells, ", "))
+		}
+		fmt.Print(script)
+		return
+	}
+	if recordDir != "" && (rpcMode || lspMode || async || listenAddr != "" || httpAddr != "") {
+		exitError("Error: --record only supports interactive and command line mode\n")
+	}
+	if scriptFile != "" && (rpcMode || lspMode || async || interactive || listenAddr != "" || httpAddr != "") {
+		exitError("Error: --script cannot be combined with other modes\n")
+	}
+	if rpcMode {
+		valueEnc := &valueEncoder{}
+		s := newSession(valueEnc)
+		atExit(s.ir.Dispose)
+		runJSONRPC(s, valueEnc, os.Stdin, os.Stdout)
+		return
+	}
+	if httpAddr != "" {
+		if err := runHTTP(httpAddr); err != nil {
+			exitError("Error: %s\n", err)
+		}
+		return
+	}
+	if listenAddr != "" {
+		if err := runDaemon(listenAddr, format, async); err != nil {
+			exitError("Error: %s\n", err)
+		}
+		return
+	}
+	s := newSession(enc)
+	atExit(s.ir.Dispose)
+	if lspMode {
+		if !runLSP(s.ir, os.Stdin, os.Stdout) {
+			exit(exitFailure)
+		}
+		return
+	}
+	if interactive && async {
+		runAsyncCommands(s.ir.Cache(), format, os.Stdin, os.Stdout)
+		return
+	}
+	var nextCmdFunc func() ([]string, error)
+	if scriptFile != "" {
+		script, err := openScript(scriptFile)
+		if err != nil {
+			exitError("Error: script: %s\n", err)
+		}
+		defer script.Close()
+		// The echo is not part of a recorded response.
+		nextCmdFunc = getCmdFromScriptFunc(script, os.Stdout)
+	} else if interactive {
+		nextCmdFunc = getCmdFromReaderFunc(os.Stdin, 0)
 	} else {
 		nextCmdFunc = getCmdFromCliFunc(os.Args[i:])
 	}
-	runCommands(ironyApp, nextCmdFunc)
+	var out io.Writer = os.Stdout
+	if recordDir != "" {
+		rec, err := newSessionRecorder(recordDir, format)
+		if err != nil {
+			exitError("Error: record: %s\n", err)
+		}
+		defer rec.Close()
+		nextCmdFunc = rec.wrap(nextCmdFunc)
+		out = rec.output(out)
+		s.enc = newResponseEncoder(format, out)
+	}
+	runCommands(s, nextCmdFunc, out)
 }
 
-func getCmdFromCliFunc(args []string) func() []string {
-	f := func() []string {
-		if args != nil {
+func getCmdFromCliFunc(args []string) func() ([]string, error) {
+	f := func() ([]string, error) {
+		if len(args) > 0 {
 			curArgs := args
 			args = nil
-			return curArgs
+			return curArgs, nil
 		}
-		return nil
+		return nil, io.EOF
 	}
 	return f
 }
@@ -165,41 +324,116 @@ func quoteParse(line string) ([]string, error) {
 	return args, nil
 }
 
-func getCmdFromStdin() []string {
-	scanner := bufio.NewScanner(os.Stdin)
-	if scanner.Scan() {
-		text := scanner.Text()
-		args, err := quoteParse(text)
-		if err == nil {
-			logDebug("Get cmd %s\n", args)
-			return args
+// getCmdFromReaderFunc reads one command per line from r. The command
+// name is the word at cmdIndex. Commands with a payload end with its
+// size in bytes, the raw content following the line is appended to the
+// returned words.
+func getCmdFromReaderFunc(r io.Reader, cmdIndex int) func() ([]string, error) {
+	br := bufio.NewReader(r)
+	cmdMap := commandMap()
+	f := func() ([]string, error) {
+		args, _, err := readCommand(br, cmdMap, cmdIndex, nil)
+		return args, err
+	}
+	return f
+}
+
+// readCommand reads the next command line from br, and its payload for
+// payload commands. Blank lines and lines matching skip are ignored.
+// The command name is the word at cmdIndex.
+func readCommand(br *bufio.Reader, cmdMap map[string]*CommandDef, cmdIndex int,
+	skip func(string) bool) ([]string, string, error) {
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return nil, "", err
+		}
+		text := strings.TrimRight(line, "\r\n")
+		if skip != nil && skip(text) {
+			continue
+		}
+		args, perr := quoteParse(text)
+		if perr != nil {
+			logger.Info("Invalid input %s\n", text)
+			return nil, text, &commandError{"invalid-input", perr.Error(), []interface{}{text}}
+		}
+		if len(args) == 0 {
+			continue
+		}
+		logger.Debug("Get cmd %s\n", args)
+		if len(args) <= cmdIndex+1 {
+			return args, text, nil
 		}
-		logInfo("Invalid input %s", scanner.Text())
-		return nil
+		if cmd, ok := cmdMap[args[cmdIndex]]; ok && cmd.Payload {
+			size, perr := parseUint(args[len(args)-1])
+			if perr != nil {
+				return nil, text, &commandError{"invalid-input", "invalid payload size", []interface{}{text}}
+			}
+			if int64(size) > maxPayload {
+				// Skip the content to stay in sync with the client.
+				if _, err := io.CopyN(ioutil.Discard, br, int64(size)); err != nil {
+					return nil, text, err
+				}
+				return nil, text, &commandError{"invalid-input", "payload too large",
+					[]interface{}{size, maxPayload}}
+			}
+			payload := make([]byte, size)
+			if _, err := io.ReadFull(br, payload); err != nil {
+				return nil, text, err
+			}
+			args = append(args, string(payload))
+		}
+		return args, text, nil
 	}
-	return nil
 }
 
-func runCommands(irony *Irony, nextCmd func() []string) {
-	cmdMap := make(map[string]*CommandDef)
-	for _, cmd := range Commands {
-		cmdMap[cmd.Name] = cmd
+// maxPayload is the largest content accepted after a command line or
+// in an HTTP request, set by --max-payload.
+var maxPayload int64 = 64 << 20
+
+// eotMarker terminates every response of the interactive protocol.
+const eotMarker = "\n;;EOT\n"
+
+// cmdLock is read locked by running commands, sessions run in parallel
+// as the TUCache serializes the libclang calls on each TU. cleanup write
+// locks it to wait for the running commands.
+var cmdLock sync.RWMutex
+
+func runCommand(s *session, cmdMap map[string]*CommandDef, cmdWords []string) error {
+	cmd, ok := cmdMap[cmdWords[0]]
+	if !ok {
+		return errUnknownCommand(cmdWords[0])
+	}
+	args, err := cmd.parseArgs(cmdWords)
+	if err != nil {
+		return err
 	}
+	cmdLock.RLock()
+	defer cmdLock.RUnlock()
+	return execCommand(s, cmd, args)
+}
+
+// runCommands executes commands until nextCmd is exhausted or the exit
+// command. Invalid commands are reported to the client and skipped.
+func runCommands(s *session, nextCmd func() ([]string, error), out io.Writer) {
+	cmdMap := commandMap()
 	for {
-		var cmd *CommandDef
-		ok := false
-		cmdWords := nextCmd()
-		if cmdWords == nil {
+		cmdWords, err := nextCmd()
+		if _, ok := err.(*commandError); !ok && err != nil {
+			if err != io.EOF {
+				logger.Info("Read command: %s\n", err)
+			}
 			return
 		}
-		if cmd, ok = cmdMap[cmdWords[0]]; !ok {
-			logInfo("Invalid command '%s'\n", cmdWords[0])
-			return
+		if err == nil {
+			err = runCommand(s, cmdMap, cmdWords)
 		}
-		if err := cmd.Run(irony, cmdWords); err != nil {
-			logInfo("Run [%s]: %s\n", cmd.Name, err)
+		if err == errExit {
 			return
 		}
-		fmt.Printf("\n;;EOT\n")
+		if err != nil {
+			s.enc.Error(err)
+		}
+		io.WriteString(out, eotMarker)
 	}
 }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kangjianbin/irony-server/irony"
+)
+
+// OptionDef describes a global option of the server. The table drives
+// the help output and the shell completion scripts, main parses the
+// options itself.
+type OptionDef struct {
+	Names []string
+	// Arg is the value of the option, nil for a flag.
+	Arg *ArgDef
+	// Joined options take their value as --NAME=VALUE.
+	Joined bool
+	// Options next to each other with the same Desc share it in the help.
+	Desc string
+}
+
+var Options []*OptionDef
+
+func option(names string, arg *ArgDef, desc string) *OptionDef {
+	return &OptionDef{strings.Split(names, ","), arg, false, desc}
+}
+
+func argRef(arg ArgDef) *ArgDef {
+	return &arg
+}
+
+const (
+	timeoutDesc = "report a timeout error if parse or complete takes longer\n" +
+		"than DURATION, e.g. 5s, and go on with fresh clang state"
+	// configHelp follows the options in the help.
+	configHelp = "--debug, --log-file, --max-candidates, --matching-style, --parse-options and\n" +
+		"--clang-header-dir override the user config file, $XDG_CONFIG_HOME/irony-server/config,\n" +
+		"and the .irony-server file of the project, where they are set as \"KEY = VALUE\"\n" +
+		"lines, e.g. max-candidates = 30.\n"
+)
+
+func initOptions() {
+	Options = []*OptionDef{
+		option("-v,--version", nil, "print the versions of the server and of libclang"),
+		option("-h,--help", nil, "show this message"),
+		option("-i,--interactive", nil, "read commands from stdin, each response ends with ;;EOT"),
+		option("--async", nil, "with -i or --listen, run \"ID COMMAND ARGS...\" requests in\n"+
+			"the background, \"cancel ID\" drops a pending request"),
+		option("--lsp", nil, "speak the Language Server Protocol on stdio"),
+		option("--jsonrpc", nil, "serve commands as JSON-RPC 2.0 methods on stdio"),
+		option("--listen", argRef(stringArg("addr")),
+			"serve interactive sessions on ADDR (unix:PATH or HOST:PORT)"),
+		option("--http", argRef(stringArg("addr")),
+			"serve commands as POST /COMMAND JSON endpoints on ADDR,\ne.g. 127.0.0.1:8080, "+
+				"the Irony-Session header names a session\nthat keeps its state across requests"),
+		option("--allow-remote", nil, "let --listen and --http serve TCP addresses other than\n"+
+			"the loopback, anyone reaching them can read your files"),
+		option("--parse-timeout", argRef(stringArg("duration")), timeoutDesc),
+		option("--complete-timeout", argRef(stringArg("duration")), timeoutDesc),
+		option("-d,--debug", nil, "enable verbose logging"),
+		option("--log-file", argRef(pathArg("path")), "write the log to PATH instead of stderr"),
+		option("--max-candidates", argRef(intArg("n")), "default limit of candidates, 0 for no limit"),
+		option("--matching-style", argRef(enumArg("style", irony.MatchingStyles...)),
+			"default matching style of candidates"),
+		option("--parse-options", argRef(stringArg("option,...")),
+			"translation unit options of parse, e.g. incomplete,keep-going"),
+		option("--clang-header-dir", argRef(pathArg("dir")), "builtin headers of clang added to the compile options"),
+		&OptionDef{[]string{"--format"}, argRef(enumArg("format", formatNames()...)), true,
+			"response format, sexp (default) or json"},
+		option("--max-payload", argRef(intArg("bytes")),
+			"reject the content of set-unsaved, edit-unsaved or an HTTP\nrequest larger than BYTES, 64 MiB by default"),
+		option("--script", argRef(fileArg("file")), "run the commands of FILE, - for stdin, skipping blank lines\n"+
+			"and # comments, and echo each command before its response"),
+		option("--record", argRef(pathArg("dir")),
+			"record commands, unsaved buffers and responses to DIR"),
+	}
+}
+
+// usage returns the option as written in the help, e.g. "--listen ADDR".
+func (opt *OptionDef) usage() string {
+	s := strings.Join(opt.Names, ", ")
+	if opt.Arg == nil {
+		return s
+	}
+	if opt.Joined {
+		return s + "=" + opt.Arg.metaName()
+	}
+	return s + " " + opt.Arg.metaName()
+}
+
+// summary returns the description of opt on one line.
+func (opt *OptionDef) summary() string {
+	return strings.Replace(opt.Desc, "\n", " ", -1)
+}
+
+// optionsHelp formats the option table, descriptions start at column 24.
+func optionsHelp() string {
+	const indent = 24
+	var s string
+	for i, opt := range Options {
+		head := "  " + opt.usage()
+		if i+1 < len(Options) && Options[i+1].Desc == opt.Desc {
+			s += head + "\n"
+			continue
+		}
+		lines := strings.Split(opt.Desc, "\n")
+		if len(head) < indent-1 {
+			s += fmt.Sprintf("%-*s%s\n", indent, head, lines[0])
+			lines = lines[1:]
+		} else {
+			s += head + "\n"
+		}
+		for _, line := range lines {
+			s += strings.Repeat(" ", indent) + line + "\n"
+		}
+	}
+	return s
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kangjianbin/irony-server/logger"
+)
+
+// Session recording. A recording directory contains:
+//   session.json  the server version and response format
+//   commands      one JSON object per command read by runCommands
+//   responses     the raw response stream
+//   unsaved/N     the buffer passed to set-unsaved by command N
+
+const (
+	recordSessionFile   = "session.json"
+	recordCommandsFile  = "commands"
+	recordResponsesFile = "responses"
+	recordUnsavedDir    = "unsaved"
+)
+
+type recordedSession struct {
+	Version string `json:"version"`
+	Format  string `json:"format"`
+}
+
+type recordedCommand struct {
+	Args []string `json:"args,omitempty"`
+	// Copy of the set-unsaved buffer, relative to the recording directory.
+	Unsaved string `json:"unsaved,omitempty"`
+	// Input line that could not be parsed.
+	Invalid string `json:"invalid,omitempty"`
+}
+
+type sessionRecorder struct {
+	dir       string
+	commands  *os.File
+	responses *os.File
+	count     int
+}
+
+func newSessionRecorder(dir string, format string) (*sessionRecorder, error) {
+	if err := os.MkdirAll(filepath.Join(dir, recordUnsavedDir), 0755); err != nil {
+		return nil, err
+	}
+	session, _ := json.Marshal(&recordedSession{GetVersion(), format})
+	if err := ioutil.WriteFile(filepath.Join(dir, recordSessionFile), session, 0644); err != nil {
+		return nil, err
+	}
+	commands, err := os.Create(filepath.Join(dir, recordCommandsFile))
+	if err != nil {
+		return nil, err
+	}
+	responses, err := os.Create(filepath.Join(dir, recordResponsesFile))
+	if err != nil {
+		commands.Close()
+		return nil, err
+	}
+	return &sessionRecorder{dir, commands, responses, 0}, nil
+}
+
+func (rec *sessionRecorder) Close() {
+	rec.commands.Close()
+	rec.responses.Close()
+}
+
+// wrap returns a command reader that records every command of nextCmd.
+func (rec *sessionRecorder) wrap(nextCmd func() ([]string, error)) func() ([]string, error) {
+	return func() ([]string, error) {
+		words, err := nextCmd()
+		var entry recordedCommand
+		if cmdErr, ok := err.(*commandError); ok && len(cmdErr.args) > 0 {
+			entry.Invalid, _ = cmdErr.args[0].(string)
+		} else if err != nil {
+			return words, err
+		} else {
+			entry.Args = words
+			if len(words) == 3 && words[0] == "set-unsaved" {
+				entry.Unsaved = rec.saveUnsaved(words[2])
+			}
+		}
+		rec.count += 1
+		data, _ := json.Marshal(&entry)
+		fmt.Fprintf(rec.commands, "%s\n", data)
+		return words, err
+	}
+}
+
+func (rec *sessionRecorder) saveUnsaved(file string) string {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		logger.Info("Record: cannot read unsaved file %s: %s\n", file, err)
+		return ""
+	}
+	name := filepath.Join(recordUnsavedDir, strconv.Itoa(rec.count))
+	if err := ioutil.WriteFile(filepath.Join(rec.dir, name), data, 0644); err != nil {
+		logger.Info("Record: %s\n", err)
+		return ""
+	}
+	return name
+}
+
+// output returns a writer that records the responses written to out.
+func (rec *sessionRecorder) output(out io.Writer) io.Writer {
+	return io.MultiWriter(out, rec.responses)
+}
+
+func readRecordedCommands(dir string) ([]recordedCommand, error) {
+	f, err := os.Open(filepath.Join(dir, recordCommandsFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var cmds []recordedCommand
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var entry recordedCommand
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, entry)
+	}
+	return cmds, scanner.Err()
+}
+
+func splitResponses(stream string) []string {
+	responses := strings.SplitAfter(stream, eotMarker)
+	if n := len(responses); n > 0 && responses[n-1] == "" {
+		responses = responses[:n-1]
+	}
+	return responses
+}
+
+// runReplay replays the session recorded in dir and prints the responses
+// that differ from the recording. It returns the number of differences.
+func runReplay(dir string, out io.Writer) (int, error) {
+	var info recordedSession
+	data, err := ioutil.ReadFile(filepath.Join(dir, recordSessionFile))
+	if err != nil {
+		return 0, err
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return 0, err
+	}
+	cmds, err := readRecordedCommands(dir)
+	if err != nil {
+		return 0, err
+	}
+	recorded, err := ioutil.ReadFile(filepath.Join(dir, recordResponsesFile))
+	if err != nil {
+		return 0, err
+	}
+
+	var replayed bytes.Buffer
+	enc := newResponseEncoder(info.Format, &replayed)
+	if enc == nil {
+		return 0, fmt.Errorf("invalid format %s", info.Format)
+	}
+	s := newSession(enc)
+	defer s.ir.Dispose()
+	next := 0
+	nextCmd := func() ([]string, error) {
+		if next >= len(cmds) {
+			return nil, io.EOF
+		}
+		entry := cmds[next]
+		next += 1
+		if entry.Args == nil {
+			return nil, &commandError{"invalid-input", "invalid command line string",
+				[]interface{}{entry.Invalid}}
+		}
+		args := append([]string{}, entry.Args...)
+		if entry.Unsaved != "" {
+			args[2] = filepath.Join(dir, entry.Unsaved)
+		}
+		return args, nil
+	}
+	runCommands(s, nextCmd, &replayed)
+
+	want := splitResponses(string(recorded))
+	got := splitResponses(replayed.String())
+	diffs := 0
+	for i := 0; i < len(want) || i < len(got); i += 1 {
+		var w, g string
+		if i < len(want) {
+			w = want[i]
+		}
+		if i < len(got) {
+			g = got[i]
+		}
+		if w == g {
+			continue
+		}
+		diffs += 1
+		cmd := ""
+		if i < len(cmds) {
+			cmd = strings.Join(cmds[i].Args, " ") + cmds[i].Invalid
+		}
+		fmt.Fprintf(out, "response %d differs (%s)\n--- recorded\n%s+++ replayed\n%s\n", i+1, cmd, w, g)
+	}
+	fmt.Fprintf(out, "replayed %d commands, %d responses differ\n", len(cmds), diffs)
+	return diffs, nil
+}
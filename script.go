@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Script mode runs the commands of a file one after the other, like the
+// interactive mode does. Blank lines and lines starting with # are
+// skipped, and every command line is echoed as ";; > LINE" before its
+// response.
+
+func isScriptComment(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "#")
+}
+
+// openScript opens the script name, "-" reads it from stdin.
+func openScript(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	return os.Open(name)
+}
+
+func getCmdFromScriptFunc(r io.Reader, echo io.Writer) func() ([]string, error) {
+	br := bufio.NewReader(r)
+	cmdMap := commandMap()
+	f := func() ([]string, error) {
+		args, text, err := readCommand(br, cmdMap, 0, isScriptComment)
+		if text != "" {
+			fmt.Fprintf(echo, ";; > %s\n", text)
+		}
+		return args, err
+	}
+	return f
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/kangjianbin/irony-server/irony"
+)
+
+// serverConfigs holds the configuration of the server, set up by main
+// from the config files and the command line.
+var serverConfigs = irony.NewConfigLoader(defaultConfig())
+
+// defaultConfig returns the engine defaults with the builtin headers
+// given at build time.
+func defaultConfig() *irony.Config {
+	cfg := irony.DefaultConfig()
+	cfg.ClangHeaderDir = ClangHeaderDir
+	return cfg
+}
+
+// session is a client of the server: an engine session and the encoder
+// of its responses.
+type session struct {
+	ir  *irony.Irony
+	enc responseEncoder
+}
+
+// capabilitiesInfo lets clients adapt to the features of this server.
+type capabilitiesInfo struct {
+	ProtocolVersion int      `json:"protocol_version"`
+	Version         string   `json:"version"`
+	ClangVersion    string   `json:"clang_version"`
+	Commands        []string `json:"commands"`
+	MatchingStyles  []string `json:"matching_styles"`
+	Formats         []string `json:"formats"`
+	Modes           []string `json:"modes"`
+}
+
+func GetVersion() string {
+	return irony.Version
+}
+
+func newSession(enc responseEncoder) *session {
+	return &session{irony.New(serverConfigs), enc}
+}
+
+// newSharedSession creates a session on top of a shared cache.
+func newSharedSession(cache *irony.TUCache, enc responseEncoder) *session {
+	return &session{irony.NewSession(cache, serverConfigs), enc}
+}
+
+func quote(s string) string {
+	return strconv.Quote(s)
+}
+
+func (s *session) GetCompileOptions(buildDir string, file string) {
+	cmds, err := s.ir.CompileCommands(buildDir, file)
+	if err != nil {
+		s.enc.Error(err)
+		return
+	}
+	s.enc.CompileCommands(cmds)
+}
+
+func (s *session) SetUnsaved(file string, unsaved string) {
+	if err := s.ir.SetUnsaved(file, unsaved); err != nil {
+		s.enc.Error(err)
+		return
+	}
+	s.enc.Success()
+}
+
+func (s *session) SetUnsavedContent(file string, content string) {
+	s.ir.SetUnsavedContent(file, content)
+	s.enc.Success()
+}
+
+func (s *session) EditUnsaved(file string, version uint32, edit *irony.Edit) {
+	if err := s.ir.EditUnsaved(file, version, edit); err != nil {
+		s.enc.Error(err)
+		return
+	}
+	s.enc.Success()
+}
+
+func (s *session) ResetUnsaved(file string) {
+	s.ir.ResetUnsaved(file)
+	s.enc.Success()
+}
+
+func (s *session) Parse(file string, flags []string) {
+	if err := s.ir.Parse(file, flags); err != nil {
+		s.enc.Error(err)
+		return
+	}
+	s.enc.Success()
+}
+
+func (s *session) Diagnostics() {
+	s.enc.Diagnostics(s.ir.Diagnostics())
+}
+
+func (s *session) CompletionDiagnostics() {
+	s.enc.Diagnostics(s.ir.CompletionDiagnostics())
+}
+
+func (s *session) Complete(file string, line, col uint32, flags []string) {
+	if err := s.ir.Complete(file, line, col, flags); err != nil {
+		s.enc.Error(err)
+		return
+	}
+	s.enc.Success()
+}
+
+// Candidates reports the candidates as a page with their total if paged,
+// as a plain list otherwise.
+func (s *session) Candidates(prefix string, opts *irony.CandidateOptions, paged bool) {
+	page, ok := s.ir.Candidates(prefix, opts)
+	if !ok {
+		s.enc.Nil()
+		return
+	}
+	if paged {
+		s.enc.CandidatePage(page)
+		return
+	}
+	s.enc.Candidates(page.Candidates)
+}
+
+func (s *session) CompletionContext() {
+	ctx, ok := s.ir.CompletionContext()
+	if !ok {
+		s.enc.Nil()
+		return
+	}
+	s.enc.CompletionContext(ctx)
+}
+
+func (s *session) ShowConfig(file string) {
+	cfg := s.ir.Config()
+	if file != "" {
+		cfg = s.ir.ConfigFor(file)
+	}
+	s.enc.Config(cfg)
+}
+
+func (s *session) GetType(line, col uint32) {
+	types := s.ir.TypeAt(line, col)
+	if types == nil {
+		s.enc.Nil()
+		return
+	}
+	s.enc.Type(types)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/kangjianbin/irony-server/logger"
+)
+
+// Exit codes of the server. A server stopped by a signal exits with 128
+// plus the signal number, like a shell reports it.
+const (
+	exitOK = 0
+	// Invalid usage, failed mode or replay differences.
+	exitFailure = 1
+	// A panic was recovered in the main goroutine.
+	exitInternalError = 2
+	exitSignalBase    = 128
+)
+
+var (
+	cleanupMu sync.Mutex
+	cleanups  = make(map[int]func())
+	cleanupID int
+)
+
+// atExit registers f to release libclang resources on exit. Cleanups run
+// in reverse order of registration with cmdLock write locked, so they
+// must not take it themselves. The returned function unregisters f.
+func atExit(f func()) func() {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+	cleanupID += 1
+	id := cleanupID
+	cleanups[id] = f
+	return func() {
+		cleanupMu.Lock()
+		delete(cleanups, id)
+		cleanupMu.Unlock()
+	}
+}
+
+// cleanup waits for the running commands, then disposes the registered
+// resources and removes the temp file.
+func cleanup() {
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+	cleanupMu.Lock()
+	var ids []int
+	for id := range cleanups {
+		ids = append(ids, id)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+	fs := make([]func(), 0, len(ids))
+	for _, id := range ids {
+		fs = append(fs, cleanups[id])
+		delete(cleanups, id)
+	}
+	cleanupMu.Unlock()
+
+	// libclang may still use the index and the TUs of a timed out command.
+	if hasRunawayCommands() {
+		logger.Info("Commands still running in libclang, skip cleanup\n")
+		fs = nil
+	}
+	for _, f := range fs {
+		f()
+	}
+	removeTempFile()
+}
+
+// exiting is set by the first exit, the sessions running in parallel
+// may fail when it closes their listener or connection.
+var exiting int32
+
+// isExiting reports whether an exit is in progress and marks one as
+// started.
+func isExiting() bool {
+	return !atomic.CompareAndSwapInt32(&exiting, 0, 1)
+}
+
+// exit releases everything and terminates the process with code. It
+// must not be called while running a command. Only the first exit
+// runs, later callers wait for it.
+func exit(code int) {
+	if isExiting() {
+		select {}
+	}
+	cleanup()
+	logger.Debug("Exit with code %d\n", code)
+	logger.Release()
+	os.Exit(code)
+}
+
+// handleSignals makes SIGINT and SIGTERM exit cleanly. A second signal
+// terminates at once, for a command stuck in libclang.
+func handleSignals() {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		logger.Info("Received %s, exiting\n", sig)
+		code := exitSignalBase + int(sig.(syscall.Signal))
+		go func() {
+			<-sigs
+			logger.Info("Received second signal, exiting without cleanup\n")
+			removeTempFile()
+			logger.Release()
+			os.Exit(code)
+		}()
+		exit(code)
+	}()
+}
@@ -3,28 +3,41 @@ package main
 import (
 	"io/ioutil"
 	"os"
+	"sync"
 )
 
 const (
 	IronyTempPrefix = "irony-temp"
 )
 
-var tempFile *os.File
+var (
+	// tempMu guards tempFile, sessions running in parallel share it.
+	tempMu   sync.Mutex
+	tempFile *os.File
+)
 
 func getTempFilePath() string {
+	tempMu.Lock()
 	if tempFile == nil {
-		var err error
-		tempFile, err = ioutil.TempFile("", IronyTempPrefix)
+		f, err := ioutil.TempFile("", IronyTempPrefix)
 		if err != nil {
+			// exitError removes the temp file.
+			tempMu.Unlock()
 			exitError("Failed to create temp file")
 		}
+		tempFile = f
 	}
-	return tempFile.Name()
+	name := tempFile.Name()
+	tempMu.Unlock()
+	return name
 }
 
-func closeTempFile() {
+func removeTempFile() {
+	tempMu.Lock()
+	defer tempMu.Unlock()
 	if tempFile != nil {
 		tempFile.Close()
+		os.Remove(tempFile.Name())
 		tempFile = nil
 	}
 }
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/kangjianbin/irony-server/irony"
+	"github.com/kangjianbin/irony-server/logger"
+)
+
+// commandTimeouts holds the deadline of the commands that may get stuck
+// in libclang, set with --parse-timeout and --complete-timeout.
+var commandTimeouts = map[string]time.Duration{}
+
+// watchedCommands counts the commands running under the watchdog. Once
+// cmdLock is write locked, only timed out commands can still be running.
+var watchedCommands int32
+
+func hasRunawayCommands() bool {
+	return atomic.LoadInt32(&watchedCommands) > 0
+}
+
+// deferredEncoder keeps the response of a command until the watchdog
+// knows the command finished in time.
+type deferredEncoder struct {
+	calls []func(responseEncoder)
+}
+
+func (enc *deferredEncoder) replay(to responseEncoder) {
+	for _, call := range enc.calls {
+		call(to)
+	}
+}
+
+func (enc *deferredEncoder) add(call func(responseEncoder)) {
+	enc.calls = append(enc.calls, call)
+}
+
+func (enc *deferredEncoder) Error(err error) {
+	enc.add(func(to responseEncoder) { to.Error(err) })
+}
+
+func (enc *deferredEncoder) Success() {
+	enc.add(func(to responseEncoder) { to.Success() })
+}
+
+func (enc *deferredEncoder) Nil() {
+	enc.add(func(to responseEncoder) { to.Nil() })
+}
+
+func (enc *deferredEncoder) Diagnostics(diags []irony.Diagnostic) {
+	enc.add(func(to responseEncoder) { to.Diagnostics(diags) })
+}
+
+func (enc *deferredEncoder) Candidates(cands []irony.Candidate) {
+	enc.add(func(to responseEncoder) { to.Candidates(cands) })
+}
+
+func (enc *deferredEncoder) CandidatePage(page *irony.CandidatePage) {
+	enc.add(func(to responseEncoder) { to.CandidatePage(page) })
+}
+
+func (enc *deferredEncoder) CompletionContext(ctx *irony.CompletionContext) {
+	enc.add(func(to responseEncoder) { to.CompletionContext(ctx) })
+}
+
+func (enc *deferredEncoder) Type(types []string) {
+	enc.add(func(to responseEncoder) { to.Type(types) })
+}
+
+func (enc *deferredEncoder) CompileCommands(cmds []irony.CompileCommand) {
+	enc.add(func(to responseEncoder) { to.CompileCommands(cmds) })
+}
+
+func (enc *deferredEncoder) Capabilities(caps *capabilitiesInfo) {
+	enc.add(func(to responseEncoder) { to.Capabilities(caps) })
+}
+
+func (enc *deferredEncoder) Config(cfg *irony.Config) {
+	enc.add(func(to responseEncoder) { to.Config(cfg) })
+}
+
+func (enc *deferredEncoder) Help(text string) {
+	enc.add(func(to responseEncoder) { to.Help(text) })
+}
+
+// execCommand runs cmd, under the watchdog if it has a timeout. The
+// caller read locks cmdLock.
+func execCommand(s *session, cmd *CommandDef, args *commandArgs) error {
+	if commandTimeouts[cmd.Name] <= 0 {
+		return cmd.Run(s, args)
+	}
+	enc := &deferredEncoder{}
+	done, err := runWatched(s.ir, cmd.Name, func(engine *irony.Irony) error {
+		return cmd.Run(&session{engine, enc}, args)
+	})
+	if done {
+		enc.replay(s.enc)
+	}
+	return err
+}
+
+// runWatched runs f on ir under the timeout of the command called name,
+// e.g. parse, and reports whether f returned in time. The caller read
+// locks cmdLock.
+func runWatched(ir *irony.Irony, name string, f func(*irony.Irony) error) (bool, error) {
+	timeout := commandTimeouts[name]
+	if timeout <= 0 {
+		return true, f(ir)
+	}
+
+	// f works on a copy of the engine session, so that it can be left
+	// behind if libclang does not return.
+	engine := ir.Fork()
+	done := make(chan error, 1)
+	atomic.AddInt32(&watchedCommands, 1)
+	go func() {
+		err := f(engine)
+		atomic.AddInt32(&watchedCommands, -1)
+		done <- err
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		ir.Join(engine)
+		return true, err
+	case <-timer.C:
+		logger.Info("Command %s timed out after %s\n", name, timeout)
+		ir.Detach(engine)
+		go func() {
+			<-done
+			cmdLock.RLock()
+			engine.Release()
+			cmdLock.RUnlock()
+		}()
+		return false, irony.NewError("timeout", "command timed out", name, timeout.String())
+	}
+}